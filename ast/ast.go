@@ -0,0 +1,162 @@
+// Package ast declares the types used to represent FSML definitions as a
+// tree of elements, text and comments, as returned by fsml.ParseFile.
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind is the kind of a node
+type Kind int
+
+const (
+	Element Kind = iota + 1
+	Text
+	Comment
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Element:
+		return "Element"
+	case Text:
+		return "Text"
+	case Comment:
+		return "Comment"
+	}
+
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Pos is a location in a definition. Line and Column start at 1,
+// a zero Line means the position is unknown.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int // counted in characters
+}
+
+// IsValid reports whether the position is known
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as file:line:col, the filename is
+// omitted when it is empty
+func (p Pos) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	if s == "" {
+		s = "-"
+	}
+
+	return s
+}
+
+// Node is an element, a text or a comment of a definition.
+//
+// Names of elements and attributes are split into a namespace prefix and
+// the local Name, Namespace is the URI the prefix is bound to. Text holds
+// the content of text and comment nodes, text is trimmed at both ends and
+// entity references are decoded.
+type Node struct {
+	Kind      Kind
+	Name      string
+	Prefix    string
+	Namespace string
+	Text      string
+	Pos       Pos
+
+	Attributes []Attribute
+	Children   []*Node
+
+	// Comments are the comments directly preceding an element or text,
+	// TrailingComments are the ones after the last child of an element
+	Comments         []*Node
+	TrailingComments []*Node
+}
+
+// QualifiedName returns the name as written in the definition
+func (n *Node) QualifiedName() string {
+	return qualifiedName(n.Prefix, n.Name)
+}
+
+// Attr returns the value of the attribute with the local name and
+// whether the node has it
+func (n *Node) Attr(name string) (string, bool) {
+	for _, attr := range n.Attributes {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Elements returns the child elements, optionally only those with the local name
+func (n *Node) Elements(name ...string) []*Node {
+	var elements []*Node
+	for _, child := range n.Children {
+		if child.Kind != Element || len(name) > 0 && child.Name != name[0] {
+			continue
+		}
+		elements = append(elements, child)
+	}
+
+	return elements
+}
+
+type Attribute struct {
+	Name      string
+	Prefix    string
+	Namespace string
+	Value     string
+	Pos       Pos
+}
+
+// QualifiedName returns the name as written in the definition
+func (a *Attribute) QualifiedName() string {
+	return qualifiedName(a.Prefix, a.Name)
+}
+
+func qualifiedName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + ":" + name
+}
+
+// Error is a problem found while parsing a definition. Expected and
+// Actual are set for errors about a token, e.g. a missing '>'.
+type Error struct {
+	Pos      Pos
+	Code     string // classifies the error, e.g. "unclosed-tag"
+	Msg      string
+	Expected []string // kinds of the tokens which would have been valid, e.g. "=" or "EndTag"
+	Actual   string   // kind of the token found instead
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList is the list of errors found in a definition, in the order
+// they were found
+type ErrorList []*Error
+
+func (list ErrorList) Error() string {
+	msgs := make([]string, len(list))
+	for i, err := range list {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
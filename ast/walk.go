@@ -0,0 +1,48 @@
+package ast
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node *Node) (w Visitor)
+}
+
+// Walk traverses a tree in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor
+// w for the comments preceding node, its children and its trailing
+// comments, in this order, followed by a call of w.Visit(nil).
+func Walk(v Visitor, node *Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	for _, c := range node.Comments {
+		Walk(v, c)
+	}
+	for _, child := range node.Children {
+		Walk(v, child)
+	}
+	for _, c := range node.TrailingComments {
+		Walk(v, c)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(*Node) bool
+
+func (f inspector) Visit(node *Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a tree in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for the comments preceding node, its children and its
+// trailing comments, followed by a call of f(nil).
+func Inspect(node *Node, f func(*Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,92 @@
+package ast
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tree() *Node {
+	return &Node{
+		Kind:     Element,
+		Name:     "Schema",
+		Comments: []*Node{{Kind: Comment, Text: "orders"}},
+		Children: []*Node{
+			{
+				Kind: Element,
+				Name: "States",
+				Children: []*Node{
+					{Kind: Element, Name: "new", Comments: []*Node{{Kind: Comment, Text: "entry"}}, Children: []*Node{{Kind: Text, Text: "label"}}},
+					{Kind: Element, Name: "paid"},
+				},
+				TrailingComments: []*Node{{Kind: Comment, Text: "end of states"}},
+			},
+		},
+	}
+}
+
+func describe(n *Node) string {
+	switch {
+	case n == nil:
+		return "nil"
+	case n.Kind == Element:
+		return n.Name
+	}
+
+	return fmt.Sprintf("%s(%s)", n.Kind, n.Text)
+}
+
+func TestInspect(t *testing.T) {
+	var visited []string
+	Inspect(tree(), func(n *Node) bool {
+		visited = append(visited, describe(n))
+		return n == nil || n.Name != "new"
+	})
+
+	assert.Equal(t, []string{
+		"Schema", "Comment(orders)", "nil",
+		"States", "new", "paid", "nil", "Comment(end of states)", "nil", "nil",
+		"nil",
+	}, visited)
+}
+
+type counter map[Kind]int
+
+func (c counter) Visit(n *Node) Visitor {
+	if n != nil {
+		c[n.Kind]++
+	}
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	c := counter{}
+	Walk(c, tree())
+
+	assert.Equal(t, counter{Element: 4, Text: 1, Comment: 3}, c)
+}
+
+func TestNode(t *testing.T) {
+	n := &Node{
+		Kind:       Element,
+		Name:       "Schema",
+		Prefix:     "fsml",
+		Attributes: []Attribute{{Name: "initial", Prefix: "fsml", Value: "new"}},
+		Children:   []*Node{{Kind: Text, Text: "x"}, {Kind: Element, Name: "States"}, {Kind: Element, Name: "Events"}},
+	}
+
+	assert.Equal(t, "fsml:Schema", n.QualifiedName())
+	assert.Equal(t, "fsml:initial", n.Attributes[0].QualifiedName())
+
+	value, ok := n.Attr("initial")
+	assert.True(t, ok)
+	assert.Equal(t, "new", value)
+	_, ok = n.Attr("final")
+	assert.False(t, ok)
+
+	assert.Len(t, n.Elements(), 2)
+	assert.Equal(t, []*Node{n.Children[1]}, n.Elements("States"))
+	assert.Equal(t, "Comment", Comment.String())
+	assert.Equal(t, "orders.xml:3:4", Pos{Filename: "orders.xml", Line: 3, Column: 4}.String())
+}
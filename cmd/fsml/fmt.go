@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/zain-bahsarat/fsml"
+)
+
+// runFmt formats the definitions in the given files, directories are
+// searched for .xml files. Without paths standard input is formatted.
+// The exit status is 1 when -l or -d find files which are not formatted and
+// -w is not given to rewrite them, it is 2 on errors.
+func runFmt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	write := flags.Bool("w", false, "write the result to the file instead of standard output")
+	diff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: fsml fmt [-l] [-w] [-d] [path ...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	f := formatter{list: *list, write: *write, diff: *diff, stdout: stdout, stderr: stderr}
+	if flags.NArg() == 0 {
+		if f.write {
+			fmt.Fprintln(stderr, "fsml fmt: cannot use -w with standard input")
+			return 2
+		}
+
+		src, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "fsml fmt: %s\n", err)
+			return 2
+		}
+		f.format("<standard input>", "", src, 0)
+
+		return f.status
+	}
+
+	for _, path := range flags.Args() {
+		err := walkDefinitions(path, func(name string, src []byte, info os.FileInfo) {
+			f.format(name, name, src, info.Mode().Perm())
+		})
+
+		if err != nil {
+			f.errorf("%s", err)
+		}
+	}
+
+	return f.status
+}
+
+type formatter struct {
+	list, write, diff bool
+	stdout, stderr    io.Writer
+	status            int
+}
+
+// format formats src, read from the file name unless it is empty
+func (f *formatter) format(label, name string, src []byte, perm os.FileMode) {
+	res, err := fsml.Format(src, fsml.WithFilename(label))
+	if err != nil {
+		f.errorf("%s", err)
+		return
+	}
+
+	if !f.list && !f.write && !f.diff {
+		if _, err := f.stdout.Write(res); err != nil {
+			f.errorf("%s", err)
+		}
+		return
+	}
+
+	if bytes.Equal(src, res) {
+		return
+	}
+
+	if f.list {
+		fmt.Fprintln(f.stdout, label)
+	}
+
+	if f.diff {
+		fmt.Fprint(f.stdout, unifiedDiff(label, string(src), string(res)))
+	}
+
+	if f.write && name != "" {
+		if err := ioutil.WriteFile(name, res, perm); err != nil {
+			f.errorf("%s", err)
+			return
+		}
+	} else if f.status == 0 {
+		f.status = 1
+	}
+}
+
+func (f *formatter) errorf(format string, args ...interface{}) {
+	fmt.Fprintf(f.stderr, format+"\n", args...)
+	f.status = 2
+}
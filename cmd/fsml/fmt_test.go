@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	unformatted = "<Schema>\n<States><new></new>\n</States>\n</Schema>\n"
+	formatted   = "<Schema>\n    <States>\n        <new/>\n    </States>\n</Schema>\n"
+)
+
+func TestFmt_Stdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	status := run([]string{"fmt"}, strings.NewReader(unformatted), &stdout, &stderr)
+
+	assert.Equal(t, 0, status)
+	assert.Equal(t, formatted, stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestFmt_Files(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.xml", unformatted)
+	b := writeFile(t, dir, "b.xml", formatted)
+	writeFile(t, dir, "notes.txt", "not a definition")
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{"fmt", "-l", dir}, nil, &stdout, &stderr))
+	assert.Equal(t, a+"\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{"fmt", "-d", a, b}, nil, &stdout, &stderr))
+	assert.Equal(t, "--- "+a+".orig\n+++ "+a+"\n"+`@@ -1,4 +1,5 @@
+ <Schema>
+-<States><new></new>
+-</States>
++    <States>
++        <new/>
++    </States>
+ </Schema>
+`, stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"fmt", "-w", dir}, nil, &stdout, &stderr))
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, formatted, readFile(t, a))
+	assert.Equal(t, 0, run([]string{"fmt", "-l", dir}, nil, &stdout, &stderr))
+	assert.Empty(t, stderr.String())
+}
+
+func TestFmt_Errors(t *testing.T) {
+	dir := t.TempDir()
+	bad := writeFile(t, dir, "bad.xml", "<Schema>\n<States>\n</Schema>")
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run([]string{"fmt", "-w", bad}, nil, &stdout, &stderr))
+	assert.Equal(t, bad+":2:1: element <States> is not closed\n", stderr.String())
+	assert.Equal(t, "<Schema>\n<States>\n</Schema>", readFile(t, bad))
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"fmt", "-w"}, strings.NewReader(formatted), &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "cannot use -w with standard input")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"lint"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "lint"`)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\nx\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n15\ny\n"
+
+	assert.Equal(t, `--- f.orig
++++ f
+@@ -1,5 +1,5 @@
+ 1
+-2
++x
+ 3
+ 4
+ 5
+@@ -11,5 +11,5 @@
+ 11
+ 12
+ 13
+-14
+ 15
++y
+`, unifiedDiff("f", a, b))
+
+	assert.Equal(t, "--- f.orig\n+++ f\n@@ -0,0 +1 @@\n+a\n", unifiedDiff("f", "", "a\n"))
+
+	// the unchanged lines around a change in a large file are not part of the table
+	var large strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintln(&large, i)
+	}
+	a = large.String()
+	b = strings.Replace(a, "\n2500\n", "\nx\n", 1)
+	assert.Equal(t, "--- f.orig\n+++ f\n@@ -2498,7 +2498,7 @@\n 2497\n 2498\n 2499\n-2500\n+x\n 2501\n 2502\n 2503\n", unifiedDiff("f", a, b))
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,85 @@
+// Command fsml works with FSML state machine definitions.
+//
+// Usage:
+//
+//	fsml <command> [arguments]
+//
+// The commands are:
+//
+//	fmt    format definitions
+//	vet    report errors and likely mistakes in definitions
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+var commands = map[string]command{
+	"fmt": runFmt,
+	"vet": runVet,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "fsml: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+
+	return cmd(args[1:], stdin, stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: fsml <command> [arguments]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s\n", name)
+	}
+}
+
+// walkDefinitions calls fn with the contents of every definition in path.
+// Directories are searched for .xml files, a file given explicitly is
+// always a definition.
+func walkDefinitions(path string, fn func(name string, src []byte, info os.FileInfo)) error {
+	return filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || name != path && !strings.HasSuffix(name, ".xml") {
+			return nil
+		}
+
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		fn(name, src, info)
+
+		return nil
+	})
+}
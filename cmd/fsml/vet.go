@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/zain-bahsarat/fsml"
+)
+
+// runVet loads the definitions in the given files and reports their
+// errors and warnings, directories are searched for .xml files. Without
+// paths standard input is checked. The exit status is 1 when there are
+// warnings, it is 2 when a definition does not load.
+func runVet(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("vet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: fsml vet [path ...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	v := vetter{stdout: stdout, stderr: stderr}
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "fsml vet: %s\n", err)
+			return 2
+		}
+		v.vet("<standard input>", src)
+
+		return v.status
+	}
+
+	for _, path := range flags.Args() {
+		err := walkDefinitions(path, func(name string, src []byte, _ os.FileInfo) {
+			v.vet(name, src)
+		})
+
+		if err != nil {
+			v.errorf("%s", err)
+		}
+	}
+
+	return v.status
+}
+
+type vetter struct {
+	stdout, stderr io.Writer
+	status         int
+}
+
+func (v *vetter) vet(name string, src []byte) {
+	sm, err := fsml.New(bytes.NewReader(src), fsml.WithFilename(name))
+	if err != nil {
+		v.errorf("%s", err)
+		return
+	}
+
+	for _, w := range sm.Warnings() {
+		fmt.Fprintln(v.stdout, w)
+		if v.status == 0 {
+			v.status = 1
+		}
+	}
+}
+
+func (v *vetter) errorf(format string, args ...interface{}) {
+	fmt.Fprintf(v.stderr, format+"\n", args...)
+	v.status = 2
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVet(t *testing.T) {
+	dir := t.TempDir()
+	clean := writeFile(t, dir, "clean.xml", `<Schema>
+    <States>
+        <new>
+            <Events>
+                <Pay targetState="new"/>
+            </Events>
+        </new>
+    </States>
+</Schema>
+`)
+	warned := writeFile(t, dir, "warned.xml", `<Schema>
+    <States>
+        <new>
+            <Events>
+                <Pay targetState="paid"/>
+            </Events>
+        </new>
+        <paid/>
+    </States>
+</Schema>
+`)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"vet", clean}, nil, &stdout, &stderr))
+	assert.Empty(t, stdout.String())
+
+	assert.Equal(t, 1, run([]string{"vet", dir}, nil, &stdout, &stderr))
+	assert.Equal(t, warned+`:8:9: state "paid" has no outgoing events`+"\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestVet_Errors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	input := "<Schema>\n<States>\n<new><Events><Pay targetState=\"payed\"/></Events></new>\n<paid/>\n</States>\n</Schema>"
+	assert.Equal(t, 2, run([]string{"vet"}, strings.NewReader(input), &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `<standard input>:3:19: targetState "payed" is not a declared state, did you mean "paid"?`)
+	assert.Empty(t, stdout.String())
+}
@@ -0,0 +1,45 @@
+package fsml
+
+import (
+	"bytes"
+
+	"github.com/zain-bahsarat/fsml/ast"
+	"github.com/zain-bahsarat/fsml/internal/parser"
+	"github.com/zain-bahsarat/fsml/internal/printer"
+)
+
+// Format returns the canonical form of a definition: elements are indented
+// by four spaces, empty elements are self-closing and comments are kept.
+// The XML declaration, processing instructions and DOCTYPE around the root
+// element are kept as written. A processing instruction inside the root
+// element cannot be kept, the definition is not formatted then. The
+// definition only has to be well-formed, it is not validated against the
+// schema. The errors are an ast.ErrorList, like the ones of ParseFile.
+func Format(src []byte, opts ...Option) ([]byte, error) {
+	o := options{limits: DefaultLimits}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	p := o.newParser(bytes.NewReader(src))
+	root := p.Parse()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, toASTErrors(errs)
+	}
+
+	if dropped := p.Dropped(); len(dropped) > 0 {
+		var errs ast.ErrorList
+		for _, n := range dropped {
+			errs = append(errs, &ast.Error{Pos: ast.Pos(n.Pos), Code: string(parser.ErrMisplacedMarkup), Msg: "processing instruction inside the root element cannot be kept"})
+		}
+
+		return nil, errs
+	}
+
+	var buf bytes.Buffer
+	if err := printer.FprintDocument(&buf, p.Prolog(), root, p.Epilog()); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+package fsml
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zain-bahsarat/fsml/ast"
+)
+
+func TestFormat(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE Schema>
+<?xml-stylesheet href="states.xsl"?>
+<Schema>
+  <States>
+	<!-- entry state -->
+	<new><Events><Pay targetState="paid"></Pay></Events></new>
+	<paid></paid>
+  </States>
+</Schema>
+<!-- generated -->`
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE Schema>
+<?xml-stylesheet href="states.xsl"?>
+<Schema>
+    <States>
+        <!-- entry state -->
+        <new>
+            <Events>
+                <Pay targetState="paid"/>
+            </Events>
+        </new>
+        <paid/>
+    </States>
+</Schema>
+<!-- generated -->
+`
+
+	out, err := Format([]byte(input))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(out))
+
+	out, err = Format(out)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(out))
+}
+
+func TestFormat_Errors(t *testing.T) {
+	_, err := Format([]byte("<Schema>\n<States>\n</Schema>"), WithFilename("orders.xml"))
+
+	var errs ast.ErrorList
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "unclosed-tag", errs[0].Code)
+	assert.Equal(t, "orders.xml:2:1: element <States> is not closed", err.Error())
+
+	_, err = Format([]byte("<Schema>\n<?render compact?>\n</Schema>"), WithFilename("orders.xml"))
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "orders.xml:2:1: processing instruction inside the root element cannot be kept", err.Error())
+	assert.True(t, errors.As(err, &errs))
+}
@@ -1,9 +1,15 @@
 module github.com/zain-bahsarat/fsml
 
-go 1.15
+go 1.18
 
 require (
 	github.com/looplab/fsm v0.2.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
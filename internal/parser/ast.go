@@ -7,16 +7,65 @@ var (
 	RootNode    NodeType = "Root"
 	TextNode    NodeType = "Text"
 	UnknownNode NodeType = "Unknown"
+	CommentNode NodeType = "Comment"
+
+	// ProcInstNode and DoctypeNode are markup around the root element,
+	// Name holds the markup as written
+	ProcInstNode NodeType = "ProcInst"
+	DoctypeNode  NodeType = "Doctype"
 )
 
+// Names of elements and attributes are split into a namespace prefix and the
+// local Name, Namespace is the URI the prefix is bound to
 type Node struct {
 	Name       string
+	Prefix     string
+	Namespace  string
 	Type       NodeType
 	Children   []Node
 	Attributes []Attribute
+	Pos        Pos
+
+	// Comments are the comments directly preceding the node,
+	// TrailingComments are the ones after its last child
+	Comments         []Node
+	TrailingComments []Node
+}
+
+// QualifiedName returns the name as written in the document
+func (n *Node) QualifiedName() string {
+	return qualifiedName(n.Prefix, n.Name)
+}
+
+// Attr returns the value of the attribute with the local name and
+// whether the node has it
+func (n *Node) Attr(name string) (string, bool) {
+	for _, attr := range n.Attributes {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
 }
 
 type Attribute struct {
-	Name  string
-	Value string
+	Name      string
+	Prefix    string
+	Namespace string
+	Value     string
+	Pos       Pos
+}
+
+// QualifiedName returns the name as written in the document
+func (a *Attribute) QualifiedName() string {
+	return qualifiedName(a.Prefix, a.Name)
+}
+
+func qualifiedName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + ":" + name
 }
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The conformance corpus is shared by Lexer and XMLTokenizer. Documents in
+// valid and errors have to give the same tree and errors with both, the
+// malformed ones only have to be rejected by both.
+func TestConformance(t *testing.T) {
+	for _, dir := range []string{"valid", "errors", "malformed"} {
+		files, err := filepath.Glob(filepath.Join("testdata", "conformance", dir, "*.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("no documents in %s", dir)
+		}
+
+		for _, file := range files {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name := filepath.Base(file)
+			lexer := New(NewReaderLexer(name, bytes.NewReader(src)))
+			tokenizer := New(NewXMLTokenizer(name, bytes.NewReader(src)))
+			lexerTree, tokenizerTree := lexer.Parse(), tokenizer.Parse()
+
+			switch dir {
+			case "valid":
+				assert.Empty(t, lexer.Errors(), file)
+				assert.Empty(t, tokenizer.Errors(), file)
+				assert.Equal(t, lexerTree, tokenizerTree, file)
+			case "errors":
+				assert.NotEmpty(t, lexer.Errors(), file)
+				assert.Equal(t, errorStrings(lexer.Errors()), errorStrings(tokenizer.Errors()), file)
+				assert.Equal(t, lexerTree, tokenizerTree, file)
+			case "malformed":
+				assert.NotEmpty(t, lexer.Errors(), file)
+				assert.NotEmpty(t, tokenizer.Errors(), file)
+			}
+		}
+	}
+}
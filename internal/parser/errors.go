@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorCode classifies a ParseError
+type ErrorCode string
+
+const (
+	ErrUnexpectedToken  ErrorCode = "unexpected-token"
+	ErrIllegalToken     ErrorCode = "illegal-token"
+	ErrMalformedTag     ErrorCode = "malformed-tag"
+	ErrMismatchedTag    ErrorCode = "mismatched-tag"
+	ErrUnclosedTag      ErrorCode = "unclosed-tag"
+	ErrMisplacedMarkup  ErrorCode = "misplaced-markup"
+	ErrMissingRoot      ErrorCode = "missing-root"
+	ErrExtraContent     ErrorCode = "extra-content"
+	ErrUnboundPrefix    ErrorCode = "unbound-prefix"
+	ErrDuplicateAttr    ErrorCode = "duplicate-attribute"
+	ErrInvalidEntity    ErrorCode = "invalid-entity"
+	ErrInvalidEncoding  ErrorCode = "invalid-encoding"
+	ErrInvalidCharacter ErrorCode = "invalid-character"
+	ErrLimitExceeded    ErrorCode = "limit-exceeded"
+	ErrRead             ErrorCode = "read-error"
+	ErrSyntax           ErrorCode = "syntax-error" // reported by XMLTokenizer
+)
+
+// ParseError describes a problem found in the input. Expected and Actual
+// are only set for unexpected tokens.
+type ParseError struct {
+	Pos      Pos
+	Code     ErrorCode
+	Msg      string
+	Expected []TokenType
+	Actual   Token
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList is the list of errors found in a document, in the order
+// they were found
+type ErrorList []*ParseError
+
+func (list ErrorList) Error() string {
+	msgs := make([]string, len(list))
+	for i, err := range list {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Err returns the list as an error, or nil when it is empty
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	return list
+}
@@ -0,0 +1,25 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorList(t *testing.T) {
+	var list ErrorList
+	assert.Nil(t, list.Err())
+
+	list = append(list,
+		&ParseError{Pos: Pos{Filename: "orders.xml", Line: 1, Column: 9}, Code: ErrUnclosedTag, Msg: "element <States> is not closed"},
+		&ParseError{Pos: Pos{Filename: "orders.xml", Line: 2, Column: 1}, Code: ErrMismatchedTag, Msg: "unexpected closing tag </a>, expected </b>"},
+	)
+
+	err := list.Err()
+	assert.EqualError(t, err, "orders.xml:1:9: element <States> is not closed\norders.xml:2:1: unexpected closing tag </a>, expected </b>")
+
+	var parseErrs ErrorList
+	assert.True(t, errors.As(err, &parseErrs))
+	assert.Equal(t, ErrMismatchedTag, parseErrs[1].Code)
+}
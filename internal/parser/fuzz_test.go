@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// addCorpus seeds f with the conformance documents, more seeds are
+// checked in under testdata/fuzz
+func addCorpus(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*", "*.xml"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src)
+	}
+}
+
+func FuzzLexer(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		l := NewReaderLexer("", bytes.NewReader(src))
+
+		// every token except EOF consumes input, so the lexer has to reach
+		// EOF before it returned more tokens than the input has bytes
+		var prev Token
+		for n := 0; ; n++ {
+			tok := l.NextToken()
+			if tok.Type == EOF {
+				break
+			}
+			if n > len(src) {
+				t.Fatalf("no EOF after %d tokens, last %v", n, tok)
+			}
+
+			if !tok.Pos.IsValid() || prev.Pos.IsValid() && isBefore(tok.Pos, prev.Pos) {
+				t.Fatalf("token %v is positioned before %v", tok, prev)
+			}
+			prev = tok
+		}
+
+		for _, err := range l.takeErrors() {
+			if !err.Pos.IsValid() {
+				t.Fatalf("error without position: %s", err)
+			}
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		tokenizers := map[string]Tokenizer{
+			"Lexer":        NewReaderLexer("", bytes.NewReader(src)),
+			"XMLTokenizer": NewXMLTokenizer("", bytes.NewReader(src)),
+		}
+
+		trees := make(map[string]*Node)
+		for name, tokenizer := range tokenizers {
+			p := NewWithLimits(tokenizer, Limits{MaxDepth: 64, MaxNodes: 10000})
+			tree := p.Parse()
+			if len(p.Errors()) == 0 {
+				trees[name] = tree
+			}
+
+			if len(p.Errors()) == 0 {
+				if tree == nil {
+					t.Fatalf("%s: no tree and no errors", name)
+				}
+				if !utf8.Valid(src) {
+					t.Fatalf("%s: invalid UTF-8 is accepted", name)
+				}
+			}
+
+			for _, err := range p.Errors() {
+				if !err.Pos.IsValid() || err.Msg == "" {
+					t.Fatalf("%s: incomplete error %#v", name, err)
+				}
+			}
+		}
+
+		// documents both accept are read the same
+		if len(trees) == 2 && !reflect.DeepEqual(trees["Lexer"], trees["XMLTokenizer"]) {
+			t.Fatalf("trees differ:\nLexer:        %+v\nXMLTokenizer: %+v", trees["Lexer"], trees["XMLTokenizer"])
+		}
+	})
+}
@@ -1,112 +1,443 @@
 package parser
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxEntityLength bounds the name of an entity reference
+	maxEntityLength = 32
+
+	// eof is the current character once the input is exhausted
+	eof rune = -1
+
+	byteOrderMark = '\uFEFF'
+)
+
 type Lexer struct {
-	input        string
-	position     int // current caracter position
-	readPosition int //(next character in input)
-	ch           byte
+	r        *bufio.Reader
+	filename string
+	ch       rune  // current character
+	line     int   // line of the current character
+	column   int   // column of the current character, counted in runes
+	read     int64 // bytes consumed from the input
+	maxBytes int64 // zero means no limit
+
+	inTag bool // between the name of a start tag and its closing '>'
+	quote rune // quote character of the attribute value being read
+
+	errors ErrorList
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFileLexer("", input)
+}
+
+// NewFileLexer creates a lexer whose token positions refer to filename
+func NewFileLexer(filename, input string) *Lexer {
+	return NewReaderLexer(filename, strings.NewReader(input))
+}
+
+// NewReaderLexer creates a lexer which reads its input incrementally from r,
+// token positions refer to filename
+func NewReaderLexer(filename string, r io.Reader) *Lexer {
+	l := &Lexer{r: bufio.NewReader(r), filename: filename, line: 1}
 	l.readChar()
+
+	// a leading byte order mark is not part of the document
+	if l.ch == byteOrderMark {
+		l.readChar()
+		l.column = 1
+	}
+
 	return l
 }
 
+// readChar decodes the next UTF-8 encoded character, invalid encodings and
+// characters not allowed in XML are reported. Line breaks, "\r\n" and a
+// lone "\r", are read as "\n" like XML 1.0 section 2.11 requires.
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
-		l.ch = 0x00
-	} else {
-		l.ch = l.input[l.readPosition]
+	if l.ch == eof {
+		return
 	}
 
-	l.position = l.readPosition
-	if l.ch != 0x00 {
-		l.readPosition += 1
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
 	}
+	l.column++
+
+	r, size, err := l.r.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			l.errorf(l.pos(), ErrRead, "read error: %s", err)
+		}
+		l.ch = eof
+		return
+	}
+
+	if r == '\r' {
+		r = '\n'
+		if b, _ := l.r.Peek(1); len(b) == 1 && b[0] == '\n' {
+			_, _ = l.r.ReadByte()
+			size++
+		}
+	}
+
+	l.read += int64(size)
+	if l.maxBytes > 0 && l.read > l.maxBytes {
+		l.errorf(l.pos(), ErrLimitExceeded, "input exceeds the limit of %d bytes", l.maxBytes)
+		l.ch = eof
+		return
+	}
+
+	l.ch = r
+	if r == utf8.RuneError && size == 1 {
+		l.errorf(l.pos(), ErrInvalidEncoding, "invalid UTF-8 encoding")
+	} else if !isXMLChar(r) {
+		l.errorf(l.pos(), ErrInvalidCharacter, "invalid character %U", r)
+	}
+}
+
+// advance appends the current character to sb and reads the next one
+func (l *Lexer) advance(sb *strings.Builder) {
+	sb.WriteRune(l.ch)
+	l.readChar()
+}
+
+// pos returns the position of the current character
+func (l *Lexer) pos() Pos {
+	return Pos{Filename: l.filename, Line: l.line, Column: l.column}
 }
 
 func (l *Lexer) NextToken() Token {
 	var tok Token
 
+	if l.quote != 0 {
+		return l.readQuoted()
+	}
+
+	// character data keeps its whitespace, runs of whitespace only are skipped
+	if !l.inTag && l.ch != '<' && l.ch != eof {
+		if text, pos, blank := l.readText(); !blank {
+			return Token{Type: String, Literal: text, Pos: pos}
+		}
+	}
+
 	// skip whitespace characters
 	l.skipWhitespace()
+	pos := l.pos()
 
 	switch l.ch {
 	case '<':
+		// markup always ends a start tag, even one missing its '>'
+		l.inTag = false
 		if l.peekChar() == '/' {
 			tok.Type = CloseTag
 			tok.Literal = l.readCloseTag()
+			tok.Pos = pos
 			return tok
-		} else if isLetter(l.peekChar()) {
+		} else if isNameStart(l.peekChar()) {
 			tok.Literal = l.readStartTag()
 			tok.Type = BeginTag
+			tok.Pos = pos
+			l.inTag = true
+			return tok
+		} else if l.hasPrefix("<!--") {
+			tok.Literal, tok.Type = l.readDelimited("<!--", "-->", Comment)
+			tok.Pos = pos
+			return tok
+		} else if l.peekChar() == '?' {
+			tok.Literal, tok.Type = l.readDelimited("<?", "?>", ProcInst)
+			tok.Pos = pos
+			return tok
+		} else if l.hasPrefix("<![CDATA[") {
+			tok.Literal, tok.Type = l.readDelimited("<![CDATA[", "]]>", CData)
+			tok.Pos = pos
+			return tok
+		} else if l.hasPrefix("<!DOCTYPE") {
+			tok.Literal, tok.Type = l.readDoctype()
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = newToken(ILLEGAL, l.ch)
 		}
 	case '>':
 		tok = newToken(EndTag, l.ch)
+		l.inTag = false
+	case '/':
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: SelfClosingTag, Literal: "/>"}
+			l.inTag = false
+		} else {
+			tok = newToken(ILLEGAL, l.ch)
+		}
 	case '"':
 		tok = newToken(DoubleQuote, l.ch)
+		if l.inTag {
+			l.quote = l.ch
+		}
+	case '\'':
+		if l.inTag {
+			tok = newToken(SingleQuote, l.ch)
+			l.quote = l.ch
+		} else {
+			tok = newToken(ILLEGAL, l.ch)
+		}
 	case '=':
 		tok = newToken(Assign, l.ch)
-	case 0x00:
+	case eof:
 		tok.Literal = ""
 		tok.Type = EOF
 	default:
-		if isLetter(l.ch) {
+		if isNameChar(l.ch) {
 			tok.Literal = l.readString()
 			tok.Type = String
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = newToken(ILLEGAL, l.ch)
 		}
 	}
 
+	tok.Pos = pos
 	l.readChar()
 	return tok
 }
 
 func (l *Lexer) readStartTag() string {
-
-	s := string(l.ch)
-	l.readChar()
-	return s + l.readString()
+	var sb strings.Builder
+	l.advance(&sb) // <
+	sb.WriteString(l.readString())
+	return sb.String()
 }
 
 func (l *Lexer) readCloseTag() string {
-	pos := l.position
+	pos := l.pos()
+	l.readChar() // one for <
+	l.readChar() // one for /
+	name := l.readString()
+
+	l.skipWhitespace()
+	if l.ch == '>' {
+		l.readChar()
+	} else {
+		l.errorf(pos, ErrMalformedTag, "closing tag </%s> is missing '>'", name)
+	}
+
+	return "</" + name + ">"
+}
+
+// readDelimited reads markup such as comments, processing instructions and
+// CDATA sections from open up to and including close. Markup which is not
+// terminated is read up to the end of input and returned as ILLEGAL.
+// Comments must not contain "--" other than in the closing "-->".
+func (l *Lexer) readDelimited(open, close string, tokenType TokenType) (string, TokenType) {
+	var sb strings.Builder
+	for range open {
+		l.advance(&sb)
+	}
+
+	reported := false
+	for l.ch != eof {
+		l.advance(&sb)
+		content := sb.String()[len(open):]
+		if strings.HasSuffix(content, close) {
+			return sb.String(), tokenType
+		}
+
+		if tokenType == Comment && !reported && l.ch != '>' && strings.HasSuffix(content, "--") {
+			pos := l.pos()
+			pos.Column -= 2
+			l.errorf(pos, ErrIllegalToken, `"--" is not allowed in comments`)
+			reported = true
+		}
+	}
 
-	l.readChar()   // one for /
-	l.readChar()   // one to reach alpha char
-	l.readString() // tagname
+	return sb.String(), ILLEGAL
+}
+
+// readDoctype reads a document type declaration, the internal subset
+// between [ and ] as well as quoted literals may contain '>'
+func (l *Lexer) readDoctype() (string, TokenType) {
+	var sb strings.Builder
+	depth := 0
+	quote := rune(0)
 
-	for l.ch != '>' && l.peekChar() != 0x00 {
+	for l.ch != eof {
+		switch {
+		case quote != 0:
+			if l.ch == quote {
+				quote = 0
+			}
+		case l.ch == '"' || l.ch == '\'':
+			quote = l.ch
+		case l.ch == '[':
+			depth++
+		case l.ch == ']':
+			depth--
+		case l.ch == '>' && depth <= 0:
+			l.advance(&sb)
+			return sb.String(), Doctype
+		}
+		l.advance(&sb)
+	}
+
+	return sb.String(), ILLEGAL
+}
+
+// readQuoted reads the attribute value up to the closing quote, which is
+// returned by the next call
+func (l *Lexer) readQuoted() Token {
+	pos := l.pos()
+
+	switch l.ch {
+	case l.quote:
+		tok := newToken(DoubleQuote, l.ch)
+		if l.quote == '\'' {
+			tok.Type = SingleQuote
+		}
+		tok.Pos = pos
+		l.quote = 0
 		l.readChar()
-		l.skipWhitespace()
+		return tok
+	case eof:
+		l.quote = 0
+		return Token{Type: EOF, Pos: pos}
 	}
 
-	l.readChar() // ending tag >
+	var sb strings.Builder
+	for l.ch != l.quote && l.ch != eof {
+		if l.ch == '&' {
+			sb.WriteString(l.readEntity())
+			continue
+		}
+		sb.WriteRune(l.ch)
+		l.readChar()
+	}
 
-	return l.input[pos:l.position]
+	return Token{Type: String, Literal: sb.String(), Pos: pos}
 }
 
-func (l *Lexer) readString() string {
-	pos := l.position
-	for isLetter(l.ch) {
+// readText reads character data up to the next markup, entity references
+// are decoded. It also returns the position of the first non whitespace
+// character and whether there was none. "]]>" is reported, it only ends
+// CDATA sections.
+func (l *Lexer) readText() (string, Pos, bool) {
+	var sb strings.Builder
+	pos, blank := l.pos(), true
+	brackets := 0
+
+	for l.ch != '<' && l.ch != eof {
+		if blank && !isWhiteSpace(l.ch) {
+			pos, blank = l.pos(), false
+		}
+
+		if l.ch == '&' {
+			sb.WriteString(l.readEntity())
+			brackets = 0
+			continue
+		}
+
+		if l.ch == '>' && brackets >= 2 {
+			end := l.pos()
+			end.Column -= 2
+			l.errorf(end, ErrIllegalToken, `"]]>" is not allowed in text`)
+		}
+		if l.ch == ']' {
+			brackets++
+		} else {
+			brackets = 0
+		}
+
+		sb.WriteRune(l.ch)
 		l.readChar()
 	}
 
-	return l.input[pos:l.position]
+	return sb.String(), pos, blank
+}
+
+// readEntity reads an entity reference and returns its replacement text,
+// invalid references are reported and returned unchanged
+func (l *Lexer) readEntity() string {
+	pos := l.pos()
+	var raw strings.Builder
+
+	l.advance(&raw) // &
+	for l.ch != ';' && (isNameChar(l.ch) || l.ch == '#') && raw.Len() <= maxEntityLength {
+		l.advance(&raw)
+	}
+
+	if l.ch != ';' {
+		l.errorf(pos, ErrInvalidEntity, "unterminated entity reference %s", raw.String())
+		return raw.String()
+	}
+
+	name := raw.String()[1:]
+	l.readChar() // ;
+
+	text, ok := decodeEntity(name)
+	if !ok {
+		l.errorf(pos, ErrInvalidEntity, "invalid entity reference &%s;", name)
+		return "&" + name + ";"
+	}
+
+	return text
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
-		return 0
+func (l *Lexer) errorf(pos Pos, code ErrorCode, format string, args ...interface{}) {
+	l.errors = append(l.errors, &ParseError{Pos: pos, Code: code, Msg: fmt.Sprintf(format, args...)})
+}
+
+// takeErrors returns and clears the errors found since the last call
+func (l *Lexer) takeErrors() ErrorList {
+	errs := l.errors
+	l.errors = nil
+	return errs
+}
+
+func (l *Lexer) setMaxBytes(n int64) {
+	l.maxBytes = n
+}
+
+func (l *Lexer) readString() string {
+	var sb strings.Builder
+	for isNameChar(l.ch) {
+		l.advance(&sb)
 	}
 
-	return l.input[l.readPosition]
+	return sb.String()
+}
+
+func (l *Lexer) peekChar() rune {
+	if l.ch == eof {
+		return eof
+	}
+
+	b, _ := l.r.Peek(utf8.UTFMax)
+	if len(b) == 0 {
+		return eof
+	}
+
+	r, _ := utf8.DecodeRune(b)
+	return r
+}
+
+// hasPrefix reports whether the input continues with the ASCII prefix,
+// starting at the current character
+func (l *Lexer) hasPrefix(prefix string) bool {
+	if l.ch != rune(prefix[0]) {
+		return false
+	}
+
+	b, _ := l.r.Peek(len(prefix) - 1)
+	return string(b) == prefix[1:]
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -115,14 +446,24 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func isWhiteSpace(ch byte) bool {
+func isWhiteSpace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
-func isLetter(ch byte) bool {
-	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch >= '0' && ch <= '9'
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_' || unicode.IsDigit(ch)
+}
+
+// isNameStart reports whether ch can start an XML name
+func isNameStart(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_' || ch == ':'
+}
+
+// isNameChar reports whether ch can be part of an XML name
+func isNameChar(ch rune) bool {
+	return isLetter(ch) || unicode.IsMark(ch) || ch == '-' || ch == '.' || ch == ':' || ch == '\u00B7'
 }
 
-func newToken(tokenType TokenType, ch byte) Token {
+func newToken(tokenType TokenType, ch rune) Token {
 	return Token{Type: tokenType, Literal: string(ch)}
 }
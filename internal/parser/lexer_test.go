@@ -1,7 +1,11 @@
 package parser
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNextToken(t *testing.T) {
@@ -132,3 +136,438 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestNextToken_Positions(t *testing.T) {
+	input := "<Schema>\n\t<Pay targetState=\"paid\">\n</Schema>"
+
+	tests := []struct {
+		expected TokenType
+		line     int
+		column   int
+	}{
+		{BeginTag, 1, 1},
+		{EndTag, 1, 8},
+		{BeginTag, 2, 2},
+		{String, 2, 7},
+		{Assign, 2, 18},
+		{DoubleQuote, 2, 19},
+		{String, 2, 20},
+		{DoubleQuote, 2, 24},
+		{EndTag, 2, 25},
+		{CloseTag, 3, 1},
+		{EOF, 3, 10},
+	}
+
+	lex := NewFileLexer("orders.xml", input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		expected := Pos{Filename: "orders.xml", Line: tt.line, Column: tt.column}
+		if tok.Pos != expected {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, expected, tok.Pos)
+		}
+	}
+}
+
+func TestNextToken_Comments(t *testing.T) {
+	input := `<!-- a <b> comment --><Schema><!----></Schema><!-- unterminated`
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+	}{
+		{Comment, "<!-- a <b> comment -->"},
+		{BeginTag, "<Schema"},
+		{EndTag, ">"},
+		{Comment, "<!---->"},
+		{CloseTag, "</Schema>"},
+		{ILLEGAL, "<!-- unterminated"},
+		{EOF, ""},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_Prolog(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE Schema [
+	<!ELEMENT Schema (States)>
+	<!ENTITY note "a > b">
+]>
+<?render mode="compact"?><Schema></Schema><?broken`
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+	}{
+		{ProcInst, `<?xml version="1.0" encoding="UTF-8"?>`},
+		{Doctype, "<!DOCTYPE Schema [\n\t<!ELEMENT Schema (States)>\n\t<!ENTITY note \"a > b\">\n]>"},
+		{ProcInst, `<?render mode="compact"?>`},
+		{BeginTag, "<Schema"},
+		{EndTag, ">"},
+		{CloseTag, "</Schema>"},
+		{ILLEGAL, "<?broken"},
+		{EOF, ""},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_SelfClosingTag(t *testing.T) {
+	input := `<new><Pay targetState="paid"/><error/><x / ></new>`
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+	}{
+		{BeginTag, "<new"},
+		{EndTag, ">"},
+		{BeginTag, "<Pay"},
+		{String, "targetState"},
+		{Assign, "="},
+		{DoubleQuote, "\""},
+		{String, "paid"},
+		{DoubleQuote, "\""},
+		{SelfClosingTag, "/>"},
+		{BeginTag, "<error"},
+		{SelfClosingTag, "/>"},
+		{BeginTag, "<x"},
+		{ILLEGAL, "/"},
+		{EndTag, ">"},
+		{CloseTag, "</new>"},
+		{EOF, ""},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_AttributeValues(t *testing.T) {
+	input := `<in-review x:label="Waiting for review > 2 days" next='awaiting.payment' empty=""><Task>send-mail</Task></in-review>`
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+	}{
+		{BeginTag, "<in-review"},
+		{String, "x:label"},
+		{Assign, "="},
+		{DoubleQuote, "\""},
+		{String, "Waiting for review > 2 days"},
+		{DoubleQuote, "\""},
+		{String, "next"},
+		{Assign, "="},
+		{SingleQuote, "'"},
+		{String, "awaiting.payment"},
+		{SingleQuote, "'"},
+		{String, "empty"},
+		{Assign, "="},
+		{DoubleQuote, "\""},
+		{DoubleQuote, "\""},
+		{EndTag, ">"},
+		{BeginTag, "<Task"},
+		{EndTag, ">"},
+		{String, "send-mail"},
+		{CloseTag, "</Task>"},
+		{CloseTag, "</in-review>"},
+		{EOF, ""},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_EntitiesAndCData(t *testing.T) {
+	input := `<Guard expr="a &lt; b &amp;&amp; c &gt; &#100;&#x41;" note='&quot;&apos;'>x&lt;=y<![CDATA[ if a < b && c ]]></Guard>`
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+	}{
+		{BeginTag, "<Guard"},
+		{String, "expr"},
+		{Assign, "="},
+		{DoubleQuote, "\""},
+		{String, "a < b && c > dA"},
+		{DoubleQuote, "\""},
+		{String, "note"},
+		{Assign, "="},
+		{SingleQuote, "'"},
+		{String, `"'`},
+		{SingleQuote, "'"},
+		{EndTag, ">"},
+		{String, "x<=y"},
+		{CData, "<![CDATA[ if a < b && c ]]>"},
+		{CloseTag, "</Guard>"},
+		{EOF, ""},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if errs := lex.takeErrors(); len(errs) > 0 {
+		t.Fatalf("unexpected lexer errors %v", errs)
+	}
+}
+
+func TestNextToken_InvalidEntities(t *testing.T) {
+	input := `<Task a="&bogus;">&#0; & b &amp</Task>`
+
+	lex := NewLexer(input)
+	for tok := lex.NextToken(); tok.Type != EOF; tok = lex.NextToken() {
+	}
+
+	expected := []string{
+		"1:10: invalid entity reference &bogus;",
+		"1:19: invalid entity reference &#0;",
+		"1:24: unterminated entity reference &",
+		"1:28: unterminated entity reference &amp",
+	}
+	if errs := lex.takeErrors(); !equalStrings(errorStrings(errs), expected) {
+		t.Fatalf("lexer errors wrong. expected=%q, got=%q", expected, errs)
+	}
+}
+
+func TestNextToken_ReservedSequences(t *testing.T) {
+	input := `<Task a="]]>"><!-- a -- b --><!--x---><!---->a]]]>b]]&gt;c</Task>`
+
+	lex := NewLexer(input)
+	for tok := lex.NextToken(); tok.Type != EOF; tok = lex.NextToken() {
+	}
+
+	expected := []string{
+		`1:22: "--" is not allowed in comments`,
+		`1:35: "--" is not allowed in comments`,
+		`1:48: "]]>" is not allowed in text`,
+	}
+	if errs := lex.takeErrors(); !equalStrings(errorStrings(errs), expected) {
+		t.Fatalf("lexer errors wrong. expected=%q, got=%q", expected, errs)
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestNextToken_Text(t *testing.T) {
+	input := "<Label>\n\tPay  now\n</Label> <Label>a<![CDATA[b]]> c </Label>"
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+	}{
+		{BeginTag, "<Label"},
+		{EndTag, ">"},
+		{String, "\n\tPay  now\n"},
+		{CloseTag, "</Label>"},
+		{BeginTag, "<Label"},
+		{EndTag, ">"},
+		{String, "a"},
+		{CData, "<![CDATA[b]]>"},
+		{String, " c "},
+		{CloseTag, "</Label>"},
+		{EOF, ""},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_UTF8(t *testing.T) {
+	input := "\uFEFF<Zustände>\n\t<Prüfung label=\"Überprüfung läuft\"/><قيد_المراجعة>تمت المراجعة</قيد_المراجعة>\n</Zustände>"
+
+	tests := []struct {
+		expected        TokenType
+		expectedLiteral string
+		line            int
+		column          int
+	}{
+		{BeginTag, "<Zustände", 1, 1},
+		{EndTag, ">", 1, 10},
+		{BeginTag, "<Prüfung", 2, 2},
+		{String, "label", 2, 11},
+		{Assign, "=", 2, 16},
+		{DoubleQuote, "\"", 2, 17},
+		{String, "Überprüfung läuft", 2, 18},
+		{DoubleQuote, "\"", 2, 35},
+		{SelfClosingTag, "/>", 2, 36},
+		{BeginTag, "<قيد_المراجعة", 2, 38},
+		{EndTag, ">", 2, 51},
+		{String, "تمت المراجعة", 2, 52},
+		{CloseTag, "</قيد_المراجعة>", 2, 64},
+		{CloseTag, "</Zustände>", 3, 1},
+		{EOF, "", 3, 12},
+	}
+
+	lex := NewLexer(input)
+
+	for i, tt := range tests {
+		tok := lex.NextToken()
+		if tok.Type != tt.expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expected, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.line || tok.Pos.Column != tt.column {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%s", i, tt.line, tt.column, tok.Pos)
+		}
+	}
+
+	if errs := lex.takeErrors(); len(errs) > 0 {
+		t.Fatalf("unexpected lexer errors %v", errs)
+	}
+}
+
+func TestNextToken_InvalidEncoding(t *testing.T) {
+	input := "<Task>ä\xff\n\x00b</Task>"
+
+	lex := NewFileLexer("orders.xml", input)
+	for tok := lex.NextToken(); tok.Type != EOF; tok = lex.NextToken() {
+	}
+
+	expected := []string{
+		"orders.xml:1:8: invalid UTF-8 encoding",
+		"orders.xml:2:1: invalid character U+0000",
+	}
+	if errs := lex.takeErrors(); !equalStrings(errorStrings(errs), expected) {
+		t.Fatalf("lexer errors wrong. expected=%q, got=%q", expected, errs)
+	}
+}
+
+func TestNextToken_LineEndings(t *testing.T) {
+	input := "<Task>a\r\nb\rc</Task>\r\n<Task>d</Task>"
+
+	lex := NewFileLexer("orders.xml", input)
+	expected := []Token{
+		{Type: BeginTag, Literal: "<Task", Pos: Pos{"orders.xml", 1, 1}},
+		{Type: EndTag, Literal: ">", Pos: Pos{"orders.xml", 1, 6}},
+		{Type: String, Literal: "a\nb\nc", Pos: Pos{"orders.xml", 1, 7}},
+		{Type: CloseTag, Literal: "</Task>", Pos: Pos{"orders.xml", 3, 2}},
+		{Type: BeginTag, Literal: "<Task", Pos: Pos{"orders.xml", 4, 1}},
+	}
+
+	for i, tt := range expected {
+		if tok := lex.NextToken(); tok != tt {
+			t.Fatalf("tests[%d] - token wrong. expected=%+v, got=%+v", i, tt, tok)
+		}
+	}
+}
+
+func TestNextToken_Reader(t *testing.T) {
+	input := `<?xml version="1.0"?><Schema><!-- ä --><Task a='1'>x &amp; ü<![CDATA[<y>]]></Task></Schema>`
+
+	expected := NewLexer(input)
+	lex := NewReaderLexer("orders.xml", iotest.OneByteReader(strings.NewReader(input)))
+
+	for i := 0; ; i++ {
+		want, got := expected.NextToken(), lex.NextToken()
+		want.Pos.Filename = "orders.xml"
+		if want != got {
+			t.Fatalf("tests[%d] - token wrong. expected=%+v, got=%+v", i, want, got)
+		}
+
+		if got.Type == EOF {
+			break
+		}
+	}
+}
+
+func TestNextToken_ReadError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("<Schema>"), errReader{errors.New("connection reset")})
+
+	lex := NewReaderLexer("orders.xml", r)
+	for tok := lex.NextToken(); tok.Type != EOF; tok = lex.NextToken() {
+	}
+
+	expected := []string{"orders.xml:1:9: read error: connection reset"}
+	if errs := lex.takeErrors(); !equalStrings(errorStrings(errs), expected) {
+		t.Fatalf("lexer errors wrong. expected=%q, got=%q", expected, errs)
+	}
+}
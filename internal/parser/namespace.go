@@ -0,0 +1,88 @@
+package parser
+
+import "strings"
+
+const (
+	// XMLNamespace is bound to the prefix xml in every document
+	XMLNamespace = "http://www.w3.org/XML/1998/namespace"
+	// XMLNSNamespace is the namespace of the xmlns attributes declaring namespaces
+	XMLNSNamespace = "http://www.w3.org/2000/xmlns/"
+)
+
+// splitName splits a qualified name into its prefix and local part,
+// names which are not of the form prefix:local have no prefix
+func splitName(qname string) (prefix, local string) {
+	i := strings.IndexByte(qname, ':')
+	if i <= 0 || i == len(qname)-1 || strings.IndexByte(qname[i+1:], ':') >= 0 {
+		return "", qname
+	}
+
+	return qname[:i], qname[i+1:]
+}
+
+// pushNamespaces opens the namespace scope of an element with the
+// declarations among its attributes
+func (p *Parser) pushNamespaces(attributes []Attribute) {
+	var scope map[string]string
+	for _, attr := range attributes {
+		switch {
+		case attr.Prefix == "" && attr.Name == "xmlns":
+			if scope == nil {
+				scope = make(map[string]string)
+			}
+			scope[""] = attr.Value
+		case attr.Prefix == "xmlns":
+			if scope == nil {
+				scope = make(map[string]string)
+			}
+			scope[attr.Name] = attr.Value
+		}
+	}
+
+	p.namespaces = append(p.namespaces, scope)
+}
+
+func (p *Parser) popNamespaces() {
+	p.namespaces = p.namespaces[:len(p.namespaces)-1]
+}
+
+// lookupNamespace returns the URI bound to prefix in the current scope
+func (p *Parser) lookupNamespace(prefix string) (string, bool) {
+	switch prefix {
+	case "xml":
+		return XMLNamespace, true
+	case "xmlns":
+		return XMLNSNamespace, true
+	}
+
+	for i := len(p.namespaces) - 1; i >= 0; i-- {
+		if uri, ok := p.namespaces[i][prefix]; ok {
+			return uri, true
+		}
+	}
+
+	// without a default namespace unprefixed names are in no namespace
+	return "", prefix == ""
+}
+
+// resolveNamespaces sets the namespace of an element and its attributes,
+// prefixes which are not declared are reported. Unprefixed attributes are
+// in no namespace, the default namespace only applies to elements.
+func (p *Parser) resolveNamespaces(n *Node) {
+	var ok bool
+	if n.Namespace, ok = p.lookupNamespace(n.Prefix); !ok {
+		p.errorf(n.Pos, ErrUnboundPrefix, "namespace prefix %q of element <%s> is not declared", n.Prefix, n.QualifiedName())
+	}
+
+	for i := range n.Attributes {
+		attr := &n.Attributes[i]
+		switch {
+		case attr.Prefix == "" && attr.Name == "xmlns":
+			attr.Namespace = XMLNSNamespace
+		case attr.Prefix != "":
+			if attr.Namespace, ok = p.lookupNamespace(attr.Prefix); !ok {
+				p.errorf(attr.Pos, ErrUnboundPrefix, "namespace prefix %q of attribute %s is not declared", attr.Prefix, attr.QualifiedName())
+			}
+		}
+	}
+}
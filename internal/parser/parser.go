@@ -2,22 +2,79 @@ package parser
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
+// Limits bound the resources used for parsing a document, so untrusted input
+// cannot exhaust memory or recurse without bounds. A zero field means no limit.
+type Limits struct {
+	MaxBytes      int64 // size of the input in bytes
+	MaxDepth      int   // nesting depth of elements
+	MaxAttributes int   // attributes per element
+	MaxNodes      int   // element, text and comment nodes in the document
+	MaxErrors     int   // errors reported before parsing stops
+}
+
+// DefaultLimits are used by New
+var DefaultLimits = Limits{
+	MaxBytes:      10 << 20,
+	MaxDepth:      128,
+	MaxAttributes: 64,
+	MaxNodes:      100000,
+	MaxErrors:     100,
+}
+
+// Tokenizer splits a document into the tokens read by the parser,
+// it is implemented by Lexer and XMLTokenizer
+type Tokenizer interface {
+	NextToken() Token
+
+	// takeErrors returns and clears the errors found since the last call
+	takeErrors() ErrorList
+	// setMaxBytes limits the size of the input, zero means no limit
+	setMaxBytes(n int64)
+}
+
 type Parser struct {
-	l      *Lexer
-	errors []string
+	l      Tokenizer
+	errors ErrorList
+	limits Limits
 
 	curToken  Token
 	peekToken Token
+
+	// comments read ahead which are not attached to a node yet
+	comments []Node
+	// markup holds the processing instructions and DOCTYPE, Parse splits
+	// them into the ones before, inside and after the root element
+	markup                  []Node
+	prolog, epilog, dropped []Node
+	// rootSeen is set once the root element starts
+	rootSeen bool
+	// open holds the qualified names of the elements being parsed, innermost last
+	open []string
+	// namespaces holds the namespace declarations of the open elements
+	namespaces []map[string]string
+
+	nodes int
+	// halted is set once a limit is exceeded, the parser only sees EOF after that
+	halted bool
 }
 
-func New(l *Lexer) *Parser {
+func New(l Tokenizer) *Parser {
+	return NewWithLimits(l, DefaultLimits)
+}
+
+// NewWithLimits creates a parser which stops with an error as soon as
+// the input exceeds one of the limits
+func NewWithLimits(l Tokenizer, limits Limits) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: ErrorList{},
+		limits: limits,
 	}
+	l.setMaxBytes(limits.MaxBytes)
 
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
@@ -28,7 +85,93 @@ func New(l *Lexer) *Parser {
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	if p.halted {
+		return
+	}
 	p.peekToken = p.l.NextToken()
+
+	// comments are kept aside and attached to the node which follows them,
+	// the XML declaration, processing instructions and DOCTYPE are skipped
+	for p.peekTokenIs(Comment) || p.peekTokenIs(ProcInst) || p.peekTokenIs(Doctype) {
+		switch p.peekToken.Type {
+		case Comment:
+			if !p.countNode(p.peekToken.Pos) {
+				return
+			}
+			p.comments = append(p.comments, Node{
+				Name: stripComment(p.peekToken.Literal),
+				Type: CommentNode,
+				Pos:  p.peekToken.Pos,
+			})
+		case ProcInst:
+			if isXMLDecl(p.peekToken.Literal) && (p.peekToken.Pos.Line != 1 || p.peekToken.Pos.Column != 1) {
+				p.errorf(p.peekToken.Pos, ErrMisplacedMarkup, "XML declaration is only allowed at the start of the document")
+			}
+			if !p.keepMarkup(ProcInstNode) {
+				return
+			}
+		case Doctype:
+			if p.rootSeen {
+				p.errorf(p.peekToken.Pos, ErrMisplacedMarkup, "DOCTYPE is only allowed before the root element")
+			}
+			if !p.keepMarkup(DoctypeNode) {
+				return
+			}
+		}
+		p.peekToken = p.l.NextToken()
+	}
+
+	for _, err := range p.l.takeErrors() {
+		p.addError(err)
+	}
+}
+
+// keepMarkup keeps the peek token as markup which is not part of the tree,
+// it returns false when the document has too many nodes
+func (p *Parser) keepMarkup(t NodeType) bool {
+	if !p.countNode(p.peekToken.Pos) {
+		return false
+	}
+
+	p.markup = append(p.markup, Node{Name: p.peekToken.Literal, Type: t, Pos: p.peekToken.Pos})
+	return true
+}
+
+// takeComments removes and returns the pending comments located before pos
+func (p *Parser) takeComments(pos Pos) []Node {
+	var comments []Node
+	for len(p.comments) > 0 && isBefore(p.comments[0].Pos, pos) {
+		comments = append(comments, p.comments[0])
+		p.comments = p.comments[1:]
+	}
+
+	return comments
+}
+
+// countNode accounts for a new node at pos, it halts the parser and
+// returns false when the document has too many nodes
+func (p *Parser) countNode(pos Pos) bool {
+	if p.limits.MaxNodes > 0 && p.nodes >= p.limits.MaxNodes {
+		p.halt(pos, "document exceeds the limit of %d nodes", p.limits.MaxNodes)
+		return false
+	}
+
+	p.nodes++
+	return true
+}
+
+// halt reports the error and stops parsing, all further tokens are EOF
+func (p *Parser) halt(pos Pos, format string, args ...interface{}) {
+	if !p.halted {
+		p.errors = append(p.errors, &ParseError{Pos: pos, Code: ErrLimitExceeded, Msg: fmt.Sprintf(format, args...)})
+	}
+	p.halted = true
+	p.curToken = Token{Type: EOF, Pos: pos}
+	p.peekToken = p.curToken
+}
+
+func isBefore(a, b Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
 }
 
 func (p *Parser) curTokenIs(t TokenType) bool {
@@ -49,125 +192,345 @@ func (p *Parser) expectPeek(t TokenType) bool {
 	}
 }
 
-func (p *Parser) expect(t TokenType) bool {
-	if !p.curTokenIs(t) {
-		p.peekError(t)
-		return false
+// Errors returns the errors found by Parse and the lexer
+func (p *Parser) Errors() ErrorList {
+	return p.errors
+}
+
+func (p *Parser) peekError(t TokenType) {
+	p.tokenError(p.peekToken, t)
+}
+
+func (p *Parser) errorf(pos Pos, code ErrorCode, format string, args ...interface{}) {
+	p.addError(&ParseError{Pos: pos, Code: code, Msg: fmt.Sprintf(format, args...)})
+}
+
+// addError records err, parsing halts once there are too many errors
+func (p *Parser) addError(err *ParseError) {
+	// errors after a halt are only consequences of it
+	if p.halted {
+		return
 	}
 
-	return true
+	p.errors = append(p.errors, err)
+	if p.limits.MaxErrors > 0 && len(p.errors) >= p.limits.MaxErrors {
+		p.halt(err.Pos, "too many errors")
+	}
 }
 
-func (p *Parser) Errors() []string {
-	return p.errors
+func (p *Parser) tokenError(tok Token, t TokenType) {
+	p.addError(&ParseError{
+		Pos:      tok.Pos,
+		Code:     ErrUnexpectedToken,
+		Msg:      fmt.Sprintf("expected next token to be %s, got %s instead", t, tok.Type),
+		Expected: []TokenType{t},
+		Actual:   tok,
+	})
 }
 
-func (p *Parser) peekError(t TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+// unexpectedError reports a token which cannot appear at its position
+func (p *Parser) unexpectedError(tok Token) {
+	if tok.Type == ILLEGAL {
+		p.errorf(tok.Pos, ErrIllegalToken, "illegal token %q", truncate(tok.Literal, 20))
+		return
+	}
+
+	p.addError(&ParseError{
+		Pos:    tok.Pos,
+		Code:   ErrUnexpectedToken,
+		Msg:    fmt.Sprintf("unexpected %s %q", tok.Type, truncate(tok.Literal, 20)),
+		Actual: tok,
+	})
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "..."
+	}
+
+	return s
 }
 
+// Parse parses a document, which must have exactly one root element.
+// Anything else than comments, processing instructions and whitespace
+// around the root element is reported. Comments before the root element
+// are attached to it, the markup around it is returned by Prolog and Epilog.
 func (p *Parser) Parse() *Node {
+	var root *Node
+	var rootEnd Pos
 
-	node := (*Node)(nil)
 	for !p.curTokenIs(EOF) {
+		switch p.curToken.Type {
+		case BeginTag:
+			if root == nil {
+				if root = p.parseNode(); root != nil {
+					root.Type = RootNode
+				}
+				rootEnd = p.curToken.Pos
+				break
+			}
 
-		n := p.parseNode()
-		if n != nil && node == nil {
-			node = n
-			node.Type = RootNode
-		} else if n != nil {
-			node.Children = append(node.Children, *node)
-			node = n
+			p.errorf(p.curToken.Pos, ErrExtraContent, "unexpected element <%s> after the root element", stripBeginTag(p.curToken.Literal))
+			p.parseNode()
+		case String, CData:
+			where := "before"
+			if root != nil {
+				where = "after"
+			}
+			p.errorf(p.curToken.Pos, ErrExtraContent, "unexpected text %s the root element", where)
+			p.parseTextNode()
+		case CloseTag:
+			p.errorf(p.curToken.Pos, ErrMismatchedTag, "unexpected closing tag %s", p.curToken.Literal)
+		default:
+			p.unexpectedError(p.curToken)
 		}
 
 		p.nextToken()
 	}
 
-	return node
+	if root == nil {
+		p.errorf(p.curToken.Pos, ErrMissingRoot, "document has no root element")
+		return nil
+	}
+
+	for _, n := range p.markup {
+		switch {
+		case isBefore(n.Pos, root.Pos):
+			p.prolog = append(p.prolog, n)
+		case isBefore(rootEnd, n.Pos):
+			p.epilog = append(p.epilog, n)
+		default:
+			p.dropped = append(p.dropped, n)
+		}
+	}
+
+	// the comments left are the ones after the root element
+	p.epilog = append(p.epilog, p.comments...)
+	p.comments = nil
+	sort.SliceStable(p.epilog, func(i, j int) bool { return isBefore(p.epilog[i].Pos, p.epilog[j].Pos) })
+
+	return root
 }
 
+// Prolog returns the XML declaration, processing instructions and DOCTYPE
+// before the root element, in the order of the document
+func (p *Parser) Prolog() []Node {
+	return p.prolog
+}
+
+// Epilog returns the comments and processing instructions after the root
+// element, in the order of the document
+func (p *Parser) Epilog() []Node {
+	return p.epilog
+}
+
+// Dropped returns the processing instructions inside the root element,
+// the tree does not keep them
+func (p *Parser) Dropped() []Node {
+	return p.dropped
+}
+
+// parseNode parses the node starting at the current token, it reports
+// tokens which cannot start a node and returns nil for them
 func (p *Parser) parseNode() *Node {
+	switch p.curToken.Type {
+	case BeginTag, String, CData:
+		if !p.countNode(p.curToken.Pos) {
+			return nil
+		}
+	}
+
 	switch p.curToken.Type {
 	case BeginTag:
 		return p.parseElementNode()
-	case String:
+	case String, CData:
 		return p.parseTextNode()
+	case EOF:
+		return nil
 	}
 
-	return &Node{}
+	p.unexpectedError(p.curToken)
+	return nil
 }
 
 func (p *Parser) parseTextNode() *Node {
-	n := Node{Type: TextNode}
+	n := Node{Type: TextNode, Pos: p.curToken.Pos}
+	n.Comments = p.takeComments(n.Pos)
 	var sb strings.Builder
 
-	sb.WriteString(p.curToken.Literal)
-	for !p.peekTokenIs(CloseTag) && !p.peekTokenIs(EOF) {
-		if !p.expectPeek(String) {
-			break
-		}
-		sb.WriteString(p.curToken.Literal)
+	sb.WriteString(p.textLiteral())
+	for p.peekTokenIs(String) || p.peekTokenIs(CData) {
+		p.nextToken()
+		sb.WriteString(p.textLiteral())
 	}
 
-	n.Name = sb.String()
+	// interior whitespace is kept, only the ends are trimmed
+	n.Name = strings.TrimSpace(sb.String())
 	return &n
 }
 
-func (p *Parser) parseElementNode() *Node {
+// textLiteral returns the character data of the current String or CData token
+func (p *Parser) textLiteral() string {
+	if p.curTokenIs(CData) {
+		return stripCData(p.curToken.Literal)
+	}
+
+	return p.curToken.Literal
+}
 
-	p.expect(BeginTag)
+func (p *Parser) parseElementNode() *Node {
+	p.rootSeen = true
+	n := Node{Type: ElementNode, Pos: p.curToken.Pos}
+	n.Comments = p.takeComments(n.Pos)
+	qname := stripBeginTag(p.curToken.Literal)
+	n.Prefix, n.Name = splitName(qname)
+
+	if p.limits.MaxDepth > 0 && len(p.open) >= p.limits.MaxDepth {
+		p.halt(n.Pos, "element nesting exceeds the limit of %d", p.limits.MaxDepth)
+		return &n
+	}
 
-	n := Node{Type: ElementNode}
-	n.Name = stripBeginTag(p.curToken.Literal)
 	if attributes := p.parseAttributes(); len(attributes) > 0 {
 		n.Attributes = attributes
 	}
 
-	p.expectPeek(EndTag) //consume EndTag '>'
+	p.pushNamespaces(n.Attributes)
+	defer p.popNamespaces()
+	p.resolveNamespaces(&n)
 
-	for !p.peekTokenIs(CloseTag) && !p.curTokenIs(EOF) {
-		p.nextToken()
-		nn := p.parseNode()
-		n.Children = append(n.Children, *nn)
+	switch {
+	case p.peekTokenIs(SelfClosingTag):
+		p.nextToken() //consume SelfClosingTag '/>'
+		return &n
+	case p.peekTokenIs(EndTag):
+		p.nextToken() //consume EndTag '>'
 	}
+	// a start tag without '>' was reported already, its content is parsed anyway
+
+	p.open = append(p.open, qname)
+	defer func() { p.open = p.open[:len(p.open)-1] }()
+
+	for {
+		switch p.peekToken.Type {
+		case EOF:
+			p.errorf(n.Pos, ErrUnclosedTag, "element <%s> is not closed", qname)
+			return &n
+		case CloseTag:
+			name := stripEndTag(p.peekToken.Literal)
+			if name == qname {
+				p.nextToken() //consume CloseTag </tag>
+				n.TrailingComments = p.takeComments(p.curToken.Pos)
+				return &n
+			}
 
-	p.expectPeek(CloseTag) //consume CloseTag </tag>
-	if n.Name != stripEndTag(p.curToken.Literal) {
-		n.Type = UnknownNode
+			// closing an enclosing element implicitly closes this one
+			if p.isOpen(name) {
+				p.errorf(n.Pos, ErrUnclosedTag, "element <%s> is not closed", qname)
+				return &n
+			}
+
+			p.errorf(p.peekToken.Pos, ErrMismatchedTag, "unexpected closing tag </%s>, expected </%s>", name, qname)
+			p.nextToken()
+		default:
+			p.nextToken()
+			if nn := p.parseNode(); nn != nil {
+				n.Children = append(n.Children, *nn)
+			}
+		}
 	}
+}
 
-	return &n
+// isOpen reports whether name is one of the elements enclosing the current one
+func (p *Parser) isOpen(name string) bool {
+	for i := len(p.open) - 2; i >= 0; i-- {
+		if p.open[i] == name {
+			return true
+		}
+	}
+
+	return false
 }
 
+// parseAttributes parses the attributes of a start tag. After a malformed
+// attribute the rest of the tag is skipped, so it is reported only once.
 func (p *Parser) parseAttributes() []Attribute {
 	attributes := make([]Attribute, 0)
-	for !p.peekTokenIs(EndTag) && !p.peekTokenIs(EOF) {
+	for p.peekTokenIs(String) {
 		attr := p.parseAttribute()
-		if attr != nil {
-			attributes = append(attributes, *attr)
-		} else {
-			p.nextToken()
+		if attr == nil {
+			p.skipTag()
+			return attributes
+		}
+
+		if first := findAttribute(attributes, attr.QualifiedName()); first != nil {
+			p.errorf(attr.Pos, ErrDuplicateAttr, "attribute %s is already given at %s", attr.QualifiedName(), first.Pos)
+			continue
+		}
+
+		attributes = append(attributes, *attr)
+		if p.limits.MaxAttributes > 0 && len(attributes) > p.limits.MaxAttributes {
+			p.halt(attr.Pos, "element exceeds the limit of %d attributes", p.limits.MaxAttributes)
+			return attributes
 		}
 	}
 
+	if !p.peekTokenIs(EndTag) && !p.peekTokenIs(SelfClosingTag) {
+		p.peekError(EndTag)
+		p.skipTag()
+	}
+
 	return attributes
 }
 
-func (p *Parser) parseAttribute() *Attribute {
-	vals := []string{}
-	for _, tok := range []TokenType{String, Assign, DoubleQuote, String, DoubleQuote} {
-		if p.expectPeek(tok) {
-			if p.curTokenIs(String) {
-				vals = append(vals, p.curToken.Literal)
-			}
+// findAttribute returns the attribute with the qualified name, or nil
+func findAttribute(attributes []Attribute, name string) *Attribute {
+	for i := range attributes {
+		if attributes[i].QualifiedName() == name {
+			return &attributes[i]
+		}
+	}
+
+	return nil
+}
+
+// skipTag skips the remaining tokens of a malformed start tag
+func (p *Parser) skipTag() {
+	for {
+		switch p.peekToken.Type {
+		case EndTag, SelfClosingTag, BeginTag, CloseTag, EOF:
+			return
 		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseAttribute() *Attribute {
+	if !p.expectPeek(String) {
+		return nil
+	}
+	attr := Attribute{Pos: p.curToken.Pos}
+	attr.Prefix, attr.Name = splitName(p.curToken.Literal)
+
+	if !p.expectPeek(Assign) {
+		return nil
+	}
+
+	if !p.peekTokenIs(DoubleQuote) && !p.peekTokenIs(SingleQuote) {
+		p.peekError(DoubleQuote)
+		return nil
+	}
+	p.nextToken()
+	quote := p.curToken.Type
+
+	// the value token is omitted for empty values
+	if p.peekTokenIs(String) {
+		p.nextToken()
+		attr.Value = p.curToken.Literal
 	}
 
-	if len(vals) < 2 {
+	if !p.expectPeek(quote) {
 		return nil
 	}
 
-	return &Attribute{Name: vals[0], Value: vals[1]}
+	return &attr
 }
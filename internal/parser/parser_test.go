@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -87,7 +88,7 @@ func TestParse(t *testing.T) {
 												Name: "Task",
 												Type: ElementNode,
 												Children: []Node{{
-													Name: "t2t3",
+													Name: "t2 t3",
 													Type: TextNode,
 												}},
 											}},
@@ -109,6 +110,578 @@ func TestParse(t *testing.T) {
 		assert.Equal(t, tt.expected.Type, tree.Type)
 		assert.True(t, len(tree.Children) > 0, "Wrong children count")
 
-		assert.Equal(t, tt.expected.Children, tree.Children, "childrens are not equal")
+		assert.Equal(t, tt.expected.Children, withoutPos(tree.Children), "childrens are not equal")
 	}
 }
+
+func TestParse_Positions(t *testing.T) {
+	input := `<Schema>
+  <States>
+    <new>
+      <Events>
+        <Pay targetState="paid">
+          <Task>charge</Task>
+        </Pay>
+      </Events>
+    </new>
+  </States>
+</Schema>`
+
+	tree := New(NewFileLexer("orders.xml", input)).Parse()
+	assert.Equal(t, Pos{Filename: "orders.xml", Line: 1, Column: 1}, tree.Pos)
+
+	pay := tree.Children[0].Children[0].Children[0].Children[0]
+	assert.Equal(t, "orders.xml:5:9", pay.Pos.String())
+	assert.Equal(t, "orders.xml:5:14", pay.Attributes[0].Pos.String())
+
+	task := pay.Children[0]
+	assert.Equal(t, "orders.xml:6:11", task.Pos.String())
+	assert.Equal(t, "orders.xml:6:17", task.Children[0].Pos.String())
+}
+
+func TestParse_ErrorPosition(t *testing.T) {
+	input := `<Schema>
+	<States
+</Schema>`
+
+	p := New(NewFileLexer("orders.xml", input))
+	p.Parse()
+
+	assert.Equal(t, []string{
+		"orders.xml:3:1: expected next token to be EndTag, got CloseTag instead",
+		"orders.xml:2:2: element <States> is not closed",
+	}, errorStrings(p.Errors()))
+}
+
+func TestParse_Comments(t *testing.T) {
+	input := `<!-- order workflow -->
+<Schema>
+	<States>
+		<!-- waiting for payment -->
+		<new>
+			<Events>
+				<!-- customer paid -->
+				<!-- second line -->
+				<Pay targetState="paid">
+					<Task><!-- inline -->charge</Task>
+				</Pay>
+				<!-- nothing after -->
+			</Events>
+		</new>
+	</States>
+</Schema>
+<!-- end -->`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+	assert.Empty(t, p.Errors())
+
+	comment := func(text string) Node {
+		return Node{Name: text, Type: CommentNode}
+	}
+
+	root := withoutPos([]Node{*tree})[0]
+	assert.Equal(t, []Node{comment("order workflow")}, root.Comments)
+	assert.Empty(t, root.TrailingComments)
+	assert.Equal(t, []Node{comment("end")}, withoutPos(p.Epilog()))
+
+	state := root.Children[0].Children[0]
+	assert.Equal(t, "new", state.Name)
+	assert.Equal(t, []Node{comment("waiting for payment")}, state.Comments)
+
+	events := state.Children[0]
+	assert.Equal(t, []Node{comment("customer paid"), comment("second line")}, events.Children[0].Comments)
+	assert.Equal(t, []Node{comment("nothing after")}, events.TrailingComments)
+
+	task := events.Children[0].Children[0]
+	assert.Equal(t, []Node{comment("inline")}, task.Children[0].Comments)
+	assert.Equal(t, "charge", task.Children[0].Name)
+}
+
+func TestParse_Prolog(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE Schema SYSTEM "fsml.dtd">
+<Schema>
+	<?editor fold="true"?>
+	<States><new></new></States>
+</Schema>`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+
+	assert.Empty(t, p.Errors())
+	assert.Equal(t, "Schema", tree.Name)
+	assert.Equal(t, RootNode, tree.Type)
+	assert.Equal(t, "States", tree.Children[0].Name)
+	assert.Equal(t, "new", tree.Children[0].Children[0].Name)
+}
+
+func TestParse_MisplacedProlog(t *testing.T) {
+	input := `
+<?xml version="1.0"?>
+<Schema>
+	<!DOCTYPE Schema>
+	<States></States>
+</Schema>`
+
+	p := New(NewLexer(input))
+	p.Parse()
+
+	assert.Equal(t, []string{
+		"2:1: XML declaration is only allowed at the start of the document",
+		"4:2: DOCTYPE is only allowed before the root element",
+	}, errorStrings(p.Errors()))
+}
+
+func TestParse_SelfClosingTags(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid"/>
+				<Cancel targetState="cancelled" errorState="error">
+					<Task>refund</Task>
+				</Cancel>
+			</Events>
+		</new>
+		<paid/>
+		<cancelled></cancelled>
+		<error />
+	</States>
+</Schema>`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+	assert.Empty(t, p.Errors())
+
+	expected := []Node{
+		{
+			Name: "new",
+			Type: ElementNode,
+			Children: []Node{{
+				Name: "Events",
+				Type: ElementNode,
+				Children: []Node{
+					{
+						Name:       "Pay",
+						Type:       ElementNode,
+						Attributes: []Attribute{{Name: "targetState", Value: "paid"}},
+					},
+					{
+						Name: "Cancel",
+						Type: ElementNode,
+						Attributes: []Attribute{
+							{Name: "targetState", Value: "cancelled"},
+							{Name: "errorState", Value: "error"},
+						},
+						Children: []Node{{
+							Name:     "Task",
+							Type:     ElementNode,
+							Children: []Node{{Name: "refund", Type: TextNode}},
+						}},
+					},
+				},
+			}},
+		},
+		{Name: "paid", Type: ElementNode},
+		{Name: "cancelled", Type: ElementNode},
+		{Name: "error", Type: ElementNode},
+	}
+
+	assert.Equal(t, expected, withoutPos(tree.Children[0].Children))
+}
+
+func TestParse_AttributeValues(t *testing.T) {
+	input := `<awaiting.payment>
+	<Events>
+		<Approve targetState='in-review' errorState="on hold" note="it's fine" empty=""/>
+	</Events>
+</awaiting.payment>`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+	assert.Empty(t, p.Errors())
+
+	assert.Equal(t, "awaiting.payment", tree.Name)
+	assert.Equal(t, []Attribute{
+		{Name: "targetState", Value: "in-review"},
+		{Name: "errorState", Value: "on hold"},
+		{Name: "note", Value: "it's fine"},
+		{Name: "empty", Value: ""},
+	}, withoutPos(tree.Children[0].Children)[0].Attributes)
+}
+
+func TestParse_UnterminatedAttributeValue(t *testing.T) {
+	p := New(NewLexer(`<new targetState="paid></new>`))
+	p.Parse()
+
+	assert.NotEmpty(t, p.Errors())
+}
+
+func TestParse_EntitiesAndCData(t *testing.T) {
+	input := `<Guards>
+	<Guard expr="total &gt; 0 &amp;&amp; paid">ok</Guard>
+	<Guard><![CDATA[total < 100 && !blocked]]></Guard>
+	<Guard>&#169;<![CDATA[<raw>]]></Guard>
+</Guards>`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+	assert.Empty(t, p.Errors())
+
+	guards := withoutPos(tree.Children)
+	assert.Equal(t, "total > 0 && paid", guards[0].Attributes[0].Value)
+	assert.Equal(t, []Node{{Name: "total < 100 && !blocked", Type: TextNode}}, guards[1].Children)
+	assert.Equal(t, []Node{{Name: "\u00a9<raw>", Type: TextNode}}, guards[2].Children)
+}
+
+func TestParse_InvalidEntity(t *testing.T) {
+	p := New(NewLexer(`<Task>a &nbsp; b</Task>`))
+	p.Parse()
+
+	assert.Equal(t, []string{"1:9: invalid entity reference &nbsp;"}, errorStrings(p.Errors()))
+}
+
+func TestParse_TextWhitespace(t *testing.T) {
+	input := `<Labels>
+	<Label>
+		Waiting for   payment
+	</Label>
+	<Label>a <![CDATA[<b>]]> c</Label>
+	<Label> &#32;x </Label>
+</Labels>`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+	assert.Empty(t, p.Errors())
+
+	labels := tree.Children
+	assert.Equal(t, "Waiting for   payment", labels[0].Children[0].Name)
+	assert.Equal(t, Pos{Line: 3, Column: 3}, labels[0].Children[0].Pos)
+	assert.Equal(t, "a <b> c", labels[1].Children[0].Name)
+	assert.Equal(t, "x", labels[2].Children[0].Name)
+}
+
+func TestParse_Limits(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new a="1" b="2" c="3"/>
+		<!-- paid -->
+		<paid/>
+	</States>
+</Schema>`
+
+	testcases := []struct {
+		limits   Limits
+		expected string
+	}{
+		{Limits{}, ""},
+		{DefaultLimits, ""},
+		{Limits{MaxDepth: 2}, "3:3: element nesting exceeds the limit of 2"},
+		{Limits{MaxAttributes: 2}, "3:20: element exceeds the limit of 2 attributes"},
+		{Limits{MaxNodes: 3}, "4:3: document exceeds the limit of 3 nodes"},
+		{Limits{MaxBytes: 20}, "3:2: input exceeds the limit of 20 bytes"},
+	}
+
+	for i, tt := range testcases {
+		p := NewWithLimits(NewLexer(input), tt.limits)
+		tree := p.Parse()
+
+		if tt.expected == "" {
+			assert.Empty(t, p.Errors(), "tests[%d]", i)
+			assert.Equal(t, "paid", tree.Children[0].Children[1].Name, "tests[%d]", i)
+			continue
+		}
+
+		assert.NotEmpty(t, p.Errors(), "tests[%d]", i)
+		assert.Equal(t, tt.expected, p.Errors()[0].Error(), "tests[%d]", i)
+	}
+}
+
+func TestParse_MaxErrors(t *testing.T) {
+	p := NewWithLimits(NewLexer(strings.Repeat("</a>\n", 10)), Limits{MaxErrors: 3})
+	p.Parse()
+
+	assert.Equal(t, []string{
+		"1:1: unexpected closing tag </a>",
+		"2:1: unexpected closing tag </a>",
+		"3:1: unexpected closing tag </a>",
+		"3:1: too many errors",
+	}, errorStrings(p.Errors()))
+	assert.Equal(t, ErrLimitExceeded, p.Errors()[3].Code)
+}
+
+func TestParse_DeepNesting(t *testing.T) {
+	input := strings.Repeat("<a>", 100000) + strings.Repeat("</a>", 100000)
+
+	p := New(NewLexer(input))
+	p.Parse()
+
+	assert.Equal(t, []string{"1:385: element nesting exceeds the limit of 128"}, errorStrings(p.Errors()))
+}
+
+func TestParse_Recovery(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState=paid></Pay>
+				<Cancel targetState="cancelled"></Refund>
+			</Events>
+		</new>
+		<paid>
+			<Events>
+		</paid>
+		<cancelled a="1" = >< </cancelled>
+	</States>
+</Schema>`
+
+	p := New(NewFileLexer("orders.xml", input))
+	tree := p.Parse()
+
+	assert.Equal(t, []string{
+		`orders.xml:5:22: expected next token to be ", got String instead`,
+		"orders.xml:6:37: unexpected closing tag </Refund>, expected </Cancel>",
+		"orders.xml:6:5: element <Cancel> is not closed",
+		"orders.xml:10:4: element <Events> is not closed",
+		`orders.xml:12:20: expected next token to be EndTag, got = instead`,
+		`orders.xml:12:23: illegal token "<"`,
+	}, errorStrings(p.Errors()))
+
+	// parsing continues after every error
+	states := tree.Children[0].Children
+	assert.Equal(t, []string{"new", "paid", "cancelled"}, []string{states[0].Name, states[1].Name, states[2].Name})
+	assert.Equal(t, "Cancel", states[0].Children[0].Children[1].Name)
+	assert.Equal(t, []Attribute{{Name: "a", Value: "1"}}, withoutPos(states[2:])[0].Attributes)
+}
+
+func TestParse_ParseError(t *testing.T) {
+	p := New(NewFileLexer("orders.xml", `<Schema><States></Schema>`))
+	p.Parse()
+
+	assert.Equal(t, ErrorList{
+		{
+			Pos:  Pos{Filename: "orders.xml", Line: 1, Column: 9},
+			Code: ErrUnclosedTag,
+			Msg:  "element <States> is not closed",
+		},
+	}, p.Errors())
+
+	p = New(NewFileLexer("orders.xml", `<Schema a></Schema>`))
+	p.Parse()
+
+	assert.Equal(t, ErrorList{
+		{
+			Pos:      Pos{Filename: "orders.xml", Line: 1, Column: 10},
+			Code:     ErrUnexpectedToken,
+			Msg:      "expected next token to be =, got EndTag instead",
+			Expected: []TokenType{Assign},
+			Actual:   Token{Type: EndTag, Literal: ">", Pos: Pos{Filename: "orders.xml", Line: 1, Column: 10}},
+		},
+	}, p.Errors())
+}
+
+func TestParse_UnclosedTags(t *testing.T) {
+	p := New(NewLexer("<Schema>\n\t<States>\n\t\t<new>"))
+	p.Parse()
+
+	assert.Equal(t, []string{
+		"3:3: element <new> is not closed",
+		"2:2: element <States> is not closed",
+		"1:1: element <Schema> is not closed",
+	}, errorStrings(p.Errors()))
+	assert.Equal(t, ErrUnclosedTag, p.Errors()[0].Code)
+}
+
+func TestParse_SingleRoot(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    "<Schema><States/></Schema>\n<!-- done -->\n",
+			expected: []string{},
+		},
+		{
+			input:    "<Schema></Schema>\n<Schema><States/></Schema>",
+			expected: []string{"2:1: unexpected element <Schema> after the root element"},
+		},
+		{
+			input:    "<Schema></Schema><a/><b></b>",
+			expected: []string{"1:18: unexpected element <a> after the root element", "1:22: unexpected element <b> after the root element"},
+		},
+		{
+			input:    "<Schema></Schema>\ntrailing garbage",
+			expected: []string{"2:1: unexpected text after the root element"},
+		},
+		{
+			input:    "leading <Schema></Schema>",
+			expected: []string{"1:1: unexpected text before the root element"},
+		},
+		{
+			input:    "<Schema></Schema></States>",
+			expected: []string{"1:18: unexpected closing tag </States>"},
+		},
+		{
+			input:    "<Schema></Schema><",
+			expected: []string{`1:18: illegal token "<"`},
+		},
+		{
+			input:    "",
+			expected: []string{"1:1: document has no root element"},
+		},
+		{
+			input:    "<?xml version=\"1.0\"?>\n<!-- only a comment -->",
+			expected: []string{"2:24: document has no root element"},
+		},
+	}
+
+	for i, tt := range testcases {
+		p := New(NewLexer(tt.input))
+		tree := p.Parse()
+
+		assert.Equal(t, tt.expected, errorStrings(p.Errors()), "tests[%d]", i)
+		if tree != nil {
+			assert.Equal(t, "Schema", tree.Name, "tests[%d]", i)
+			assert.Equal(t, RootNode, tree.Type, "tests[%d]", i)
+		}
+	}
+}
+
+// Before single roots were enforced, a second top-level element replaced the
+// root and the previous root was appended to its own children.
+func TestParse_SecondRootDoesNotCorruptTree(t *testing.T) {
+	p := New(NewLexer(`<Schema><States><new/></States></Schema><Other><x/></Other>`))
+	tree := p.Parse()
+
+	assert.Len(t, p.Errors(), 1)
+	assert.Equal(t, ErrExtraContent, p.Errors()[0].Code)
+	assert.Equal(t, "Schema", tree.Name)
+	assert.Equal(t, []Node{{
+		Name:     "States",
+		Type:     ElementNode,
+		Children: []Node{{Name: "new", Type: ElementNode}},
+	}}, withoutPos(tree.Children))
+}
+
+func TestParse_Namespaces(t *testing.T) {
+	input := `<config xmlns="urn:config" xmlns:fsml="urn:fsml">
+	<fsml:Schema>
+		<fsml:States fsml:kind="list" note="x" xml:lang="en">
+			<new xmlns="" />
+		</fsml:States>
+	</fsml:Schema>
+</config>`
+
+	p := New(NewLexer(input))
+	tree := p.Parse()
+	assert.Empty(t, p.Errors())
+
+	assert.Equal(t, "config", tree.Name)
+	assert.Equal(t, "urn:config", tree.Namespace)
+	assert.Equal(t, []Attribute{
+		{Name: "xmlns", Value: "urn:config", Namespace: XMLNSNamespace},
+		{Name: "fsml", Prefix: "xmlns", Value: "urn:fsml", Namespace: XMLNSNamespace},
+	}, withoutAttributePos(tree.Attributes))
+
+	schema := tree.Children[0]
+	assert.Equal(t, []string{"Schema", "fsml", "urn:fsml", "fsml:Schema"}, []string{schema.Name, schema.Prefix, schema.Namespace, schema.QualifiedName()})
+
+	states := schema.Children[0]
+	assert.Equal(t, "urn:fsml", states.Namespace)
+	assert.Equal(t, []Attribute{
+		{Name: "kind", Prefix: "fsml", Value: "list", Namespace: "urn:fsml"},
+		{Name: "note", Value: "x"},
+		{Name: "lang", Prefix: "xml", Value: "en", Namespace: XMLNamespace},
+	}, withoutAttributePos(states.Attributes))
+
+	// the default namespace is undeclared again
+	assert.Equal(t, "", states.Children[0].Namespace)
+}
+
+func TestParse_UnboundPrefix(t *testing.T) {
+	input := `<fsml:Schema a:b="c">
+	<fsml:States></fsml:States>
+</fsml:Schema>`
+
+	p := New(NewLexer(input))
+	p.Parse()
+
+	assert.Equal(t, []string{
+		`1:1: namespace prefix "fsml" of element <fsml:Schema> is not declared`,
+		`1:14: namespace prefix "a" of attribute a:b is not declared`,
+		`2:2: namespace prefix "fsml" of element <fsml:States> is not declared`,
+	}, errorStrings(p.Errors()))
+	assert.Equal(t, ErrUnboundPrefix, p.Errors()[0].Code)
+}
+
+func TestParse_DuplicateAttribute(t *testing.T) {
+	p := New(NewLexer(`<Pay targetState="paid" targetState="bogus" a:b="1" xmlns:a="urn:a" a:b="2"/>`))
+	root := p.Parse()
+
+	assert.Equal(t, []string{
+		"1:25: attribute targetState is already given at 1:6",
+		"1:69: attribute a:b is already given at 1:45",
+	}, errorStrings(p.Errors()))
+	assert.Equal(t, ErrDuplicateAttr, p.Errors()[0].Code)
+
+	// the first one is kept
+	value, _ := root.Attr("targetState")
+	assert.Equal(t, "paid", value)
+}
+
+func TestParse_PrefixedCloseTag(t *testing.T) {
+	p := New(NewLexer(`<a:Schema xmlns:a="urn:a" xmlns:b="urn:a"></b:Schema>`))
+	p.Parse()
+
+	// close tags are matched by their qualified name, not the namespace
+	assert.Equal(t, []string{
+		"1:43: unexpected closing tag </b:Schema>, expected </a:Schema>",
+		"1:1: element <a:Schema> is not closed",
+	}, errorStrings(p.Errors()))
+}
+
+func errorStrings(list ErrorList) []string {
+	msgs := make([]string, len(list))
+	for i, err := range list {
+		msgs[i] = err.Error()
+	}
+
+	return msgs
+}
+
+// withoutPos returns a copy of the nodes with all positions cleared
+func withoutPos(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+
+	out := make([]Node, len(nodes))
+	for i, n := range nodes {
+		n.Pos = Pos{}
+		n.Children = withoutPos(n.Children)
+		n.Comments = withoutPos(n.Comments)
+		n.TrailingComments = withoutPos(n.TrailingComments)
+		if n.Attributes != nil {
+			attrs := make([]Attribute, len(n.Attributes))
+			for j, attr := range n.Attributes {
+				attr.Pos = Pos{}
+				attrs[j] = attr
+			}
+			n.Attributes = attrs
+		}
+		out[i] = n
+	}
+
+	return out
+}
+
+func withoutAttributePos(attributes []Attribute) []Attribute {
+	result := make([]Attribute, len(attributes))
+	for i, attr := range attributes {
+		attr.Pos = Pos{}
+		result[i] = attr
+	}
+
+	return result
+}
@@ -1,12 +1,48 @@
 package parser
 
-import "strings"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Pos
+}
+
+// Pos is a location in the input. Line and Column start at 1,
+// a zero Line means the position is unknown.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position is known
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as file:line:col, the filename is
+// omitted when it is empty
+func (p Pos) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	if s == "" {
+		s = "-"
+	}
+
+	return s
 }
 
 const (
@@ -15,12 +51,18 @@ const (
 	EOF     = "EOF"
 
 	// Delimiters
-	BeginTag = "BeginTag" // <tag
-	EndTag   = "EndTag"   // >
-	CloseTag = "CloseTag" // </tag>
+	BeginTag       = "BeginTag"       // <tag
+	EndTag         = "EndTag"         // >
+	SelfClosingTag = "SelfClosingTag" // />
+	CloseTag       = "CloseTag"       // </tag>
+	Comment        = "Comment"        // <!-- comment -->
+	ProcInst       = "ProcInst"       // <?target instruction?>
+	Doctype        = "Doctype"        // <!DOCTYPE ...>
+	CData          = "CData"          // <![CDATA[ text ]]>
 
 	Assign      = "="
 	DoubleQuote = "\""
+	SingleQuote = "'"
 
 	// Types
 	String = "String"
@@ -39,3 +81,60 @@ func stripEndTag(tag string) string {
 	}
 	return tag
 }
+
+func stripComment(comment string) string {
+	comment = strings.TrimPrefix(comment, "<!--")
+	comment = strings.TrimSuffix(comment, "-->")
+	return strings.TrimSpace(comment)
+}
+
+// isXMLDecl reports whether the processing instruction is the XML declaration
+func isXMLDecl(procInst string) bool {
+	target := strings.TrimPrefix(procInst, "<?")
+	return target == "xml?>" || strings.HasPrefix(target, "xml") && len(target) > 3 && isWhiteSpace(rune(target[3]))
+}
+
+func stripCData(cdata string) string {
+	cdata = strings.TrimPrefix(cdata, "<![CDATA[")
+	return strings.TrimSuffix(cdata, "]]>")
+}
+
+var predefinedEntities = map[string]string{
+	"amp":  "&",
+	"lt":   "<",
+	"gt":   ">",
+	"quot": "\"",
+	"apos": "'",
+}
+
+// decodeEntity returns the text of the entity reference &name;, both
+// predefined entities and numeric character references are supported
+func decodeEntity(name string) (string, bool) {
+	if s, ok := predefinedEntities[name]; ok {
+		return s, true
+	}
+
+	if !strings.HasPrefix(name, "#") {
+		return "", false
+	}
+
+	num, base := name[1:], 10
+	if strings.HasPrefix(num, "x") {
+		num, base = num[1:], 16
+	}
+
+	n, err := strconv.ParseUint(num, base, 32)
+	if err != nil || !isXMLChar(rune(n)) {
+		return "", false
+	}
+
+	return string(rune(n)), true
+}
+
+// isXMLChar reports whether r is allowed in an XML document
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
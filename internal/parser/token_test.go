@@ -13,3 +13,51 @@ func TestStripEndTag(t *testing.T) {
 func TestStripBeginTag(t *testing.T) {
 	assert.Equal(t, "BeginTag", stripBeginTag("<BeginTag>"))
 }
+
+func TestStripComment(t *testing.T) {
+	assert.Equal(t, "a comment", stripComment("<!-- a comment -->"))
+}
+
+func TestIsXMLDecl(t *testing.T) {
+	assert.True(t, isXMLDecl(`<?xml version="1.0"?>`))
+	assert.True(t, isXMLDecl(`<?xml?>`))
+	assert.False(t, isXMLDecl(`<?xml-stylesheet href="a.xsl"?>`))
+	assert.False(t, isXMLDecl(`<?render?>`))
+}
+
+func TestDecodeEntity(t *testing.T) {
+	testcases := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"amp", "&", true},
+		{"lt", "<", true},
+		{"gt", ">", true},
+		{"quot", "\"", true},
+		{"apos", "'", true},
+		{"#65", "A", true},
+		{"#x263A", "\u263a", true},
+		{"#0", "", false},
+		{"#xD800", "", false},
+		{"#x", "", false},
+		{"nbsp", "", false},
+	}
+
+	for _, tt := range testcases {
+		text, ok := decodeEntity(tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+		assert.Equal(t, tt.expected, text, tt.name)
+	}
+}
+
+func TestStripCData(t *testing.T) {
+	assert.Equal(t, " a < b ", stripCData("<![CDATA[ a < b ]]>"))
+}
+
+func TestPosString(t *testing.T) {
+	assert.Equal(t, "-", Pos{}.String())
+	assert.Equal(t, "orders.xml", Pos{Filename: "orders.xml"}.String())
+	assert.Equal(t, "3:7", Pos{Line: 3, Column: 7}.String())
+	assert.Equal(t, "orders.xml:3:7", Pos{Filename: "orders.xml", Line: 3, Column: 7}.String())
+}
@@ -0,0 +1,326 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var errInputLimit = errors.New("input limit exceeded")
+
+// XMLTokenizer reads the tokens of a document with the encoding/xml decoder
+// in strict mode. Unlike Lexer it stops at the first malformed construct,
+// for well-formed input both produce the same tokens and so the same tree.
+type XMLTokenizer struct {
+	d        *xml.Decoder
+	src      *sourceReader
+	filename string
+
+	// tokens holds the tokens read ahead, a start tag becomes several
+	tokens []Token
+	// selfClosing is set after a self-closing tag, encoding/xml reports
+	// an end element for it which is not part of the input
+	selfClosing bool
+	done        bool
+	eof         Pos
+
+	errors ErrorList
+}
+
+// NewXMLTokenizer creates a tokenizer which reads its input incrementally
+// from r, token positions refer to filename
+func NewXMLTokenizer(filename string, r io.Reader) *XMLTokenizer {
+	br := bufio.NewReader(r)
+
+	// a leading byte order mark is not part of the document
+	if b, _ := br.Peek(3); bytes.Equal(b, []byte("\uFEFF")) {
+		_, _ = br.Discard(3)
+	}
+
+	src := &sourceReader{r: br, filename: filename, line: 1, column: 1}
+	d := xml.NewDecoder(src)
+	d.Strict = true
+
+	return &XMLTokenizer{d: d, src: src, filename: filename}
+}
+
+func (t *XMLTokenizer) NextToken() Token {
+	for len(t.tokens) == 0 {
+		t.readTokens()
+	}
+
+	tok := t.tokens[0]
+	t.tokens = t.tokens[1:]
+	return tok
+}
+
+// readTokens translates the next token of the decoder, blank text between
+// markup does not produce any token
+func (t *XMLTokenizer) readTokens() {
+	if t.done {
+		t.emit(EOF, "", t.eof)
+		return
+	}
+
+	start := t.d.InputOffset()
+	tok, err := t.d.RawToken()
+	end := t.d.InputOffset()
+	if err != nil {
+		t.fail(err, end)
+		return
+	}
+	raw := t.src.bytes(start, end)
+	if !t.checkChars(tok, raw, start) {
+		return
+	}
+
+	switch tok := tok.(type) {
+	case xml.StartElement:
+		t.startTag(tok, start, raw)
+	case xml.EndElement:
+		if t.selfClosing {
+			t.selfClosing = false
+			return
+		}
+		name := raw[2:bytes.IndexFunc(raw, func(r rune) bool { return r == '>' || isWhiteSpace(r) })]
+		t.emitAt(CloseTag, "</"+string(name)+">", start)
+	case xml.CharData:
+		if bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+			t.emitAt(CData, "<![CDATA["+string(tok)+"]]>", start)
+			return
+		}
+
+		// the text starts at its first non whitespace character
+		if i := bytes.IndexFunc(raw, func(r rune) bool { return !isWhiteSpace(r) }); i >= 0 {
+			t.emitAt(String, string(tok), start+int64(i))
+		}
+	case xml.Comment:
+		t.emitAt(Comment, normalizeNewlines(raw), start)
+	case xml.ProcInst:
+		t.emitAt(ProcInst, normalizeNewlines(raw), start)
+	case xml.Directive:
+		if bytes.HasPrefix(raw, []byte("<!DOCTYPE")) {
+			t.emitAt(Doctype, normalizeNewlines(raw), start)
+		} else {
+			t.emitAt(ILLEGAL, normalizeNewlines(raw), start)
+		}
+	}
+}
+
+// startTag splits a start tag into the tokens Lexer returns for it,
+// the names and positions are taken from the raw input
+func (t *XMLTokenizer) startTag(el xml.StartElement, start int64, raw []byte) {
+	i := nameEnd(raw, 1)
+	t.emitAt(BeginTag, string(raw[:i]), start)
+
+	for _, attr := range el.Attr {
+		i = skipSpace(raw, i)
+		j := nameEnd(raw, i)
+		t.emitAt(String, string(raw[i:j]), start+int64(i))
+
+		i = skipSpace(raw, j)
+		t.emitAt(Assign, "=", start+int64(i))
+
+		i = skipSpace(raw, i+1)
+		quote := raw[i]
+		quoteType := TokenType(DoubleQuote)
+		if quote == '\'' {
+			quoteType = SingleQuote
+		}
+		t.emitAt(quoteType, string(quote), start+int64(i))
+
+		// the value token is omitted for empty values
+		j = i + 1 + bytes.IndexByte(raw[i+1:], quote)
+		if j > i+1 {
+			t.emitAt(String, attr.Value, start+int64(i+1))
+		}
+		t.emitAt(quoteType, string(quote), start+int64(j))
+		i = j + 1
+	}
+
+	if bytes.HasSuffix(raw, []byte("/>")) {
+		t.emitAt(SelfClosingTag, "/>", start+int64(len(raw)-2))
+		t.selfClosing = true
+	} else {
+		t.emitAt(EndTag, ">", start+int64(len(raw)-1))
+	}
+}
+
+// checkChars reports what the decoder lets through but Lexer rejects:
+// invalid encodings and characters in comments, processing instructions and
+// declarations, whose content the decoder does not check, and character
+// references to characters not allowed in XML, which it replaces. The input
+// ends at the first of them.
+func (t *XMLTokenizer) checkChars(tok xml.Token, raw []byte, start int64) bool {
+	switch tok.(type) {
+	case xml.Comment, xml.ProcInst, xml.Directive:
+		for i := 0; i < len(raw); {
+			r, size := utf8.DecodeRune(raw[i:])
+			if r == utf8.RuneError && size == 1 {
+				t.stop(start+int64(i), ErrInvalidEncoding, "invalid UTF-8 encoding")
+				return false
+			} else if !isXMLChar(r) {
+				t.stop(start+int64(i), ErrInvalidCharacter, "invalid character %U", r)
+				return false
+			}
+			i += size
+		}
+	case xml.StartElement, xml.CharData:
+		if bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+			return true
+		}
+
+		for i := 0; i < len(raw); i++ {
+			if raw[i] != '&' {
+				continue
+			}
+
+			j := bytes.IndexByte(raw[i:], ';')
+			if j < 0 {
+				continue
+			}
+
+			if name := string(raw[i+1 : i+j]); strings.HasPrefix(name, "#") {
+				if _, ok := decodeEntity(name); !ok {
+					t.stop(start+int64(i), ErrInvalidEntity, "invalid entity reference &%s;", name)
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// stop ends the input with an error at the offset
+func (t *XMLTokenizer) stop(offset int64, code ErrorCode, format string, args ...interface{}) {
+	t.done = true
+	t.eof = t.src.pos(offset)
+	t.errorf(t.eof, code, format, args...)
+	t.emit(EOF, "", t.eof)
+}
+
+// fail ends the input, decoder errors other than the end of input are reported
+func (t *XMLTokenizer) fail(err error, offset int64) {
+	t.done = true
+	t.eof = t.src.pos(offset)
+
+	var syntaxErr *xml.SyntaxError
+	switch {
+	case err == io.EOF:
+	case err == errInputLimit:
+		t.errorf(t.eof, ErrLimitExceeded, "input exceeds the limit of %d bytes", t.src.maxBytes)
+	case errors.As(err, &syntaxErr):
+		t.errorf(t.eof, ErrSyntax, "%s", syntaxErr.Msg)
+	default:
+		t.errorf(t.eof, ErrRead, "read error: %s", err)
+	}
+
+	t.emit(EOF, "", t.eof)
+}
+
+func (t *XMLTokenizer) emitAt(tokenType TokenType, literal string, offset int64) {
+	t.emit(tokenType, literal, t.src.pos(offset))
+}
+
+func (t *XMLTokenizer) emit(tokenType TokenType, literal string, pos Pos) {
+	t.tokens = append(t.tokens, Token{Type: tokenType, Literal: literal, Pos: pos})
+}
+
+func (t *XMLTokenizer) errorf(pos Pos, code ErrorCode, format string, args ...interface{}) {
+	t.errors = append(t.errors, &ParseError{Pos: pos, Code: code, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (t *XMLTokenizer) takeErrors() ErrorList {
+	errs := t.errors
+	t.errors = nil
+	return errs
+}
+
+func (t *XMLTokenizer) setMaxBytes(n int64) {
+	t.src.maxBytes = n
+}
+
+// normalizeNewlines returns raw markup with line breaks read as "\n", the
+// decoder does so for text and attribute values only
+func normalizeNewlines(raw []byte) string {
+	s := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+func skipSpace(raw []byte, i int) int {
+	for i < len(raw) && isWhiteSpace(rune(raw[i])) {
+		i++
+	}
+
+	return i
+}
+
+// nameEnd returns the end of the name starting at raw[i]
+func nameEnd(raw []byte, i int) int {
+	for i < len(raw) && !isWhiteSpace(rune(raw[i])) && raw[i] != '=' && raw[i] != '/' && raw[i] != '>' {
+		i++
+	}
+
+	return i
+}
+
+// sourceReader passes the input to the decoder. It keeps the bytes
+// which are not located yet, so decoder offsets can be turned into
+// line and column positions.
+type sourceReader struct {
+	r        io.Reader
+	filename string
+	read     int64 // bytes passed to the decoder
+	maxBytes int64 // zero means no limit
+
+	buf          []byte // input from offset on
+	offset       int64
+	line, column int  // position of buf[0]
+	cr           bool // the last character located was '\r'
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.maxBytes > 0 && s.read+int64(n) > s.maxBytes {
+		n = int(s.maxBytes - s.read)
+		err = errInputLimit
+	}
+
+	s.read += int64(n)
+	s.buf = append(s.buf, p[:n]...)
+	return n, err
+}
+
+// bytes returns the input between the offsets, start must not be located yet
+func (s *sourceReader) bytes(start, end int64) []byte {
+	return s.buf[start-s.offset : end-s.offset]
+}
+
+// pos returns the position of the input offset, offsets have to be
+// located in increasing order
+func (s *sourceReader) pos(offset int64) Pos {
+	for s.offset < offset && len(s.buf) > 0 {
+		// "\r\n" and a lone "\r" are a single line break
+		r, size := utf8.DecodeRune(s.buf)
+		switch {
+		case r == '\n' && s.cr:
+		case r == '\n' || r == '\r':
+			s.line++
+			s.column = 1
+		default:
+			s.column++
+		}
+		s.cr = r == '\r'
+
+		s.buf = s.buf[size:]
+		s.offset += int64(size)
+	}
+
+	return Pos{Filename: s.filename, Line: s.line, Column: s.column}
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXMLTokenizer_SameTokens(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "valid", "*.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := NewReaderLexer(file, bytes.NewReader(src))
+		x := NewXMLTokenizer(file, bytes.NewReader(src))
+		for {
+			expected, tok := l.NextToken(), x.NextToken()
+			if !assert.Equal(t, expected, tok, file) || tok.Type == EOF {
+				break
+			}
+		}
+	}
+}
+
+func TestXMLTokenizer_Errors(t *testing.T) {
+	testcases := []struct {
+		input    string
+		limits   Limits
+		expected []string
+	}{
+		{
+			input:    "<Schema>\n\t<Task>a &unknown; b</Task>\n</Schema>",
+			expected: []string{"2:19: invalid character entity &unknown;", "2:2: element <Task> is not closed", "1:1: element <Schema> is not closed"},
+		},
+		{
+			input:    "<Schema><States/></Schema>",
+			limits:   Limits{MaxBytes: 10},
+			expected: []string{"1:11: input exceeds the limit of 10 bytes", "1:1: element <Schema> is not closed"},
+		},
+		{
+			input:    "<Schema><a/><b/><c/></Schema>",
+			limits:   Limits{MaxNodes: 3},
+			expected: []string{"1:17: document exceeds the limit of 3 nodes"},
+		},
+		{
+			input:    "<Schema><Events></Events><!--\xc8--></Schema>",
+			expected: []string{"1:30: invalid UTF-8 encoding", "1:1: element <Schema> is not closed"},
+		},
+		{
+			input:    "<Schema>\r\n<!-- \x07 -->\r\n</Schema>",
+			expected: []string{"2:6: invalid character U+0007", "1:1: element <Schema> is not closed"},
+		},
+		{
+			input:    "<Schema>\n\t<Task note=\"&#xD800;\"/>\n</Schema>",
+			expected: []string{"2:14: invalid entity reference &#xD800;", "1:1: element <Schema> is not closed"},
+		},
+	}
+
+	for i, tt := range testcases {
+		p := NewWithLimits(NewXMLTokenizer("", strings.NewReader(tt.input)), tt.limits)
+		p.Parse()
+
+		assert.Equal(t, tt.expected, errorStrings(p.Errors()), "tests[%d]", i)
+	}
+}
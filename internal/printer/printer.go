@@ -0,0 +1,157 @@
+// Package printer writes parsed documents and schemas as canonical FSML.
+//
+// Elements are indented by four spaces per level, elements without content
+// are self-closing and an element whose only content is text is written on
+// one line. Comments are kept, the output parses to the same tree.
+package printer
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zain-bahsarat/fsml/internal/parser"
+	"github.com/zain-bahsarat/fsml/internal/schema"
+)
+
+// Indent is written once per nesting level
+const Indent = "    "
+
+var (
+	// a carriage return is written as a reference, parsers read it as a line break otherwise
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#13;")
+	// attribute values are normalized by parsers, line breaks and tabs
+	// become spaces unless they are written as references
+	attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;",
+		"\n", "&#10;", "\r", "&#13;", "\t", "&#9;")
+)
+
+// Fprint writes the canonical form of the document with root element n to w
+func Fprint(w io.Writer, n *parser.Node) error {
+	var p printer
+	p.comments(n.Comments, 0)
+	p.node(n, 0)
+
+	_, err := io.WriteString(w, p.sb.String())
+	return err
+}
+
+// FprintDocument writes the canonical form of a document to w, the markup
+// of the prolog and epilog is written unchanged before and after the root
+// element n
+func FprintDocument(w io.Writer, prolog []parser.Node, n *parser.Node, epilog []parser.Node) error {
+	// comments before the root element are attached to it, they are written
+	// in their place among the prolog
+	before := append(append([]parser.Node(nil), prolog...), n.Comments...)
+	sort.SliceStable(before, func(i, j int) bool { return isBefore(before[i].Pos, before[j].Pos) })
+
+	root := *n
+	root.Comments = nil
+
+	var p printer
+	p.misc(before)
+	p.node(&root, 0)
+	p.misc(epilog)
+
+	_, err := io.WriteString(w, p.sb.String())
+	return err
+}
+
+// FprintSchema writes the canonical definition of s to w. Default events
+// without tasks are left out, they have no effect.
+func FprintSchema(w io.Writer, s *schema.Schema) error {
+	return Fprint(w, schemaNode(s))
+}
+
+type printer struct {
+	sb strings.Builder
+}
+
+func (p *printer) line(depth int, s ...string) {
+	p.sb.WriteString(strings.Repeat(Indent, depth))
+	for _, part := range s {
+		p.sb.WriteString(part)
+	}
+	p.sb.WriteByte('\n')
+}
+
+func (p *printer) comments(comments []parser.Node, depth int) {
+	for _, c := range comments {
+		p.line(depth, comment(c.Name))
+	}
+}
+
+// misc writes the comments, processing instructions and DOCTYPE outside
+// of the root element
+func (p *printer) misc(nodes []parser.Node) {
+	for i := range nodes {
+		p.node(&nodes[i], 0)
+	}
+}
+
+func (p *printer) node(n *parser.Node, depth int) {
+	switch n.Type {
+	case parser.TextNode:
+		p.line(depth, text(n.Name))
+		return
+	case parser.CommentNode:
+		p.line(depth, comment(n.Name))
+		return
+	case parser.ProcInstNode, parser.DoctypeNode:
+		p.line(depth, n.Name)
+		return
+	}
+
+	tag := startTag(n)
+	switch {
+	case len(n.Children) == 0 && len(n.TrailingComments) == 0:
+		p.line(depth, tag, "/>")
+	case len(n.Children) == 1 && n.Children[0].Type == parser.TextNode &&
+		len(n.Children[0].Comments) == 0 && len(n.TrailingComments) == 0:
+		p.line(depth, tag, ">", text(n.Children[0].Name), "</", n.QualifiedName(), ">")
+	default:
+		p.line(depth, tag, ">")
+		for i := range n.Children {
+			p.comments(n.Children[i].Comments, depth+1)
+			p.node(&n.Children[i], depth+1)
+		}
+		p.comments(n.TrailingComments, depth+1)
+		p.line(depth, "</", n.QualifiedName(), ">")
+	}
+}
+
+func startTag(n *parser.Node) string {
+	var sb strings.Builder
+	sb.WriteString("<")
+	sb.WriteString(n.QualifiedName())
+	for _, attr := range n.Attributes {
+		sb.WriteString(" ")
+		sb.WriteString(attr.QualifiedName())
+		sb.WriteString(`="`)
+		sb.WriteString(attributeEscaper.Replace(attr.Value))
+		sb.WriteString(`"`)
+	}
+
+	return sb.String()
+}
+
+func isBefore(a, b parser.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func text(s string) string {
+	// an empty text node only comes from an empty CDATA section
+	if s == "" {
+		return "<![CDATA[]]>"
+	}
+
+	return textEscaper.Replace(s)
+}
+
+func comment(s string) string {
+	if s == "" {
+		return "<!-- -->"
+	}
+
+	return "<!-- " + s + " -->"
+}
@@ -0,0 +1,200 @@
+package printer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zain-bahsarat/fsml/internal/parser"
+	"github.com/zain-bahsarat/fsml/internal/schema"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFprint(t *testing.T) {
+	input := `<!-- orders -->
+<Schema><OnStateSet>
+  <Task>audit</Task></OnStateSet>
+	<States>
+		<!-- entry state -->
+		<new><Events><Pay targetState='paid' errorState="error"  note="a &lt; b &quot;c&quot;">
+		<Task>  charge card </Task><Task><![CDATA[x < y]]></Task></Pay></Events>
+		<!-- no more events -->
+		</new>
+		<paid></paid>
+		<error/>
+	</States>
+</Schema>
+<!-- end -->`
+
+	expected := `<!-- orders -->
+<Schema>
+    <OnStateSet>
+        <Task>audit</Task>
+    </OnStateSet>
+    <States>
+        <!-- entry state -->
+        <new>
+            <Events>
+                <Pay targetState="paid" errorState="error" note="a &lt; b &quot;c&quot;">
+                    <Task>charge card</Task>
+                    <Task>x &lt; y</Task>
+                </Pay>
+            </Events>
+            <!-- no more events -->
+        </new>
+        <paid/>
+        <error/>
+    </States>
+</Schema>
+<!-- end -->
+`
+
+	assert.Equal(t, expected, print(t, input))
+}
+
+func TestFprint_WhitespaceReferences(t *testing.T) {
+	// other parsers would turn the characters into spaces or line breaks
+	assert.Equal(t, "<Schema x=\"a&#10;b&#9;c&#13;d\">t&#13;x</Schema>\n", print(t, `<Schema x="a&#10;b&#9;c&#13;d">t&#13;x</Schema>`))
+}
+
+func TestFprint_RoundTrip(t *testing.T) {
+	testcases := []string{
+		`<Schema/>`,
+		`<Schema><States><new></new></States></Schema>`,
+		`<Schema>text <!-- between --> more<a x="&amp;&lt;&gt;&apos;&quot;"/>tail</Schema>`,
+		`<Schema><![CDATA[]]><Task><![CDATA[a]]>&amp;b</Task></Schema>`,
+		`<Schema><a><!-- only a comment --></a><b>x<!---->y</b></Schema>`,
+		`<fsml:Schema xmlns:fsml="urn:fsml" xmlns="urn:default"><fsml:States fsml:kind="x"><new/></fsml:States></fsml:Schema>`,
+		"<Schema>\n\t<!--\n\t\tmulti\n\t\tline\n\t-->\n\t<Task>ünïcode ✓ مرحبا</Task>\n</Schema>",
+		`<Schema x="a&#10;b&#9;c&#13;d">t&#13;x</Schema>`,
+	}
+
+	for i, input := range testcases {
+		first := parse(t, input)
+		printed := print(t, input)
+		second := parse(t, printed)
+
+		assert.Equal(t, withoutPos(*first), withoutPos(*second), "tests[%d]", i)
+		assert.Equal(t, printed, print(t, printed), "tests[%d] - printing is not stable", i)
+	}
+}
+
+func TestFprintSchema(t *testing.T) {
+	input := `<Schema initial="new">
+	<OnBeforeEvent><Task>log</Task></OnBeforeEvent>
+	<OnAfterEvent></OnAfterEvent>
+	<States>
+		<new>
+			<OnStateSet><Task>notify</Task></OnStateSet>
+			<Events>
+				<Pay targetState="paid" errorState="error"><Task>charge</Task></Pay>
+				<Cancel targetState="cancelled"/>
+			</Events>
+		</new>
+		<paid final="true"/>
+		<error/>
+		<cancelled final="true"/>
+	</States>
+</Schema>`
+
+	expected := `<Schema initial="new">
+    <OnBeforeEvent>
+        <Task>log</Task>
+    </OnBeforeEvent>
+    <States>
+        <new>
+            <OnStateSet>
+                <Task>notify</Task>
+            </OnStateSet>
+            <Events>
+                <Pay targetState="paid" errorState="error">
+                    <Task>charge</Task>
+                </Pay>
+                <Cancel targetState="cancelled"/>
+            </Events>
+        </new>
+        <paid final="true"/>
+        <error/>
+        <cancelled final="true"/>
+    </States>
+</Schema>
+`
+
+	s, err := schema.New(parser.New(parser.NewLexer(input)))
+	assert.Nil(t, err)
+
+	var sb strings.Builder
+	assert.Nil(t, FprintSchema(&sb, s))
+	assert.Equal(t, expected, sb.String())
+
+	// the printed definition describes the same schema
+	s2, err := schema.New(parser.New(parser.NewLexer(sb.String())))
+	assert.Nil(t, err)
+
+	var sb2 strings.Builder
+	assert.Nil(t, FprintSchema(&sb2, s2))
+	assert.Equal(t, sb.String(), sb2.String())
+}
+
+func parse(t *testing.T, input string) *parser.Node {
+	t.Helper()
+
+	n, _ := parseDocument(t, input)
+	return n
+}
+
+func parseDocument(t *testing.T, input string) (*parser.Node, *parser.Parser) {
+	t.Helper()
+
+	p := parser.New(parser.NewLexer(input))
+	n := p.Parse()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors in %q: %s", input, p.Errors())
+	}
+
+	return n, p
+}
+
+func print(t *testing.T, input string) string {
+	t.Helper()
+
+	n, p := parseDocument(t, input)
+
+	var sb strings.Builder
+	if err := FprintDocument(&sb, p.Prolog(), n, p.Epilog()); err != nil {
+		t.Fatal(err)
+	}
+
+	return sb.String()
+}
+
+func withoutPos(n parser.Node) parser.Node {
+	n.Pos = parser.Pos{}
+	n.Children = withoutPosAll(n.Children)
+	n.Comments = withoutPosAll(n.Comments)
+	n.TrailingComments = withoutPosAll(n.TrailingComments)
+
+	if n.Attributes != nil {
+		attributes := make([]parser.Attribute, len(n.Attributes))
+		for i, attr := range n.Attributes {
+			attr.Pos = parser.Pos{}
+			attributes[i] = attr
+		}
+		n.Attributes = attributes
+	}
+
+	return n
+}
+
+func withoutPosAll(nodes []parser.Node) []parser.Node {
+	if nodes == nil {
+		return nil
+	}
+
+	result := make([]parser.Node, len(nodes))
+	for i, n := range nodes {
+		result[i] = withoutPos(n)
+	}
+
+	return result
+}
@@ -0,0 +1,90 @@
+package printer
+
+import (
+	"github.com/zain-bahsarat/fsml/internal/parser"
+	"github.com/zain-bahsarat/fsml/internal/schema"
+)
+
+// schemaNode builds the document tree of a schema
+func schemaNode(s *schema.Schema) *parser.Node {
+	states := element(schema.StatesNodeName)
+	for _, st := range s.States {
+		states.Children = append(states.Children, stateNode(st))
+	}
+
+	root := element(schema.SchemaNodeName)
+	root.Type = parser.RootNode
+	if s.Initial != "" {
+		root.Attributes = []parser.Attribute{{Name: schema.Initial, Value: s.Initial}}
+	}
+	root.Children = append(defaultEventNodes(s.DefaultEvents), states)
+	return &root
+}
+
+func stateNode(st schema.State) parser.Node {
+	n := element(st.Name)
+	if st.Final {
+		n.Attributes = []parser.Attribute{{Name: schema.Final, Value: "true"}}
+	}
+	n.Children = defaultEventNodes(st.DefaultEvents)
+
+	if len(st.Events) > 0 {
+		events := element(schema.EventsNodeName)
+		for _, evt := range st.Events {
+			events.Children = append(events.Children, customEventNode(evt))
+		}
+		n.Children = append(n.Children, events)
+	}
+
+	return n
+}
+
+func defaultEventNodes(events schema.DefaultEvents) []parser.Node {
+	var nodes []parser.Node
+	for _, evt := range []struct {
+		name  string
+		event schema.Event
+	}{
+		{schema.OnBeforeEvent, events.OnBeforeEvent},
+		{schema.OnAfterEvent, events.OnAfterEvent},
+		{schema.OnStateSet, events.OnStateSet},
+	} {
+		if len(evt.event.Tasks) == 0 {
+			continue
+		}
+
+		n := element(evt.name)
+		n.Children = taskNodes(evt.event.Tasks)
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func customEventNode(evt schema.CustomEvent) parser.Node {
+	n := element(evt.Name)
+	if evt.TargetState != "" {
+		n.Attributes = append(n.Attributes, parser.Attribute{Name: schema.TargetState, Value: evt.TargetState})
+	}
+	if evt.ErrorState != "" {
+		n.Attributes = append(n.Attributes, parser.Attribute{Name: schema.ErrorState, Value: evt.ErrorState})
+	}
+
+	n.Children = taskNodes(evt.Tasks)
+	return n
+}
+
+func taskNodes(tasks []schema.TaskRef) []parser.Node {
+	var nodes []parser.Node
+	for _, task := range tasks {
+		n := element(schema.TaskNodeName)
+		n.Children = []parser.Node{{Name: task.Name, Type: parser.TextNode}}
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func element(name string) parser.Node {
+	return parser.Node{Name: name, Type: parser.ElementNode}
+}
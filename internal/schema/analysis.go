@@ -0,0 +1,90 @@
+package schema
+
+import (
+	"fmt"
+
+	"github.com/zain-bahsarat/fsml/internal/parser"
+)
+
+// Codes of the warnings reported by Analyze
+const (
+	WarnUnreachable = "unreachable" // no transition leads to the state
+	WarnErrorOnly   = "error-only"  // only error transitions lead to the state
+	WarnDeadEnd     = "dead-end"    // the state is not final and has no outgoing events
+)
+
+// Warning is a finding of Analyze, the schema is usable nonetheless
+type Warning struct {
+	Pos   parser.Pos
+	Code  string
+	State string
+	Msg   string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Pos, w.Msg)
+}
+
+// Analyze walks the transitions from the initial state and reports the
+// states which cannot be reached, which are reached only when an event
+// fails and which have no way out. Warnings follow the order of the states.
+func Analyze(s Schema) []Warning {
+	if len(s.States) == 0 {
+		return nil
+	}
+
+	initial := s.InitialState()
+	reachable := reachableStates(s, initial, false)
+	reachableOnError := reachableStates(s, initial, true)
+
+	var warnings []Warning
+	for _, st := range s.States {
+		switch {
+		case !reachableOnError[st.Name]:
+			warnings = append(warnings, Warning{Pos: st.Pos, Code: WarnUnreachable, State: st.Name,
+				Msg: fmt.Sprintf("state %q cannot be reached from the initial state %q", st.Name, initial)})
+		case !reachable[st.Name]:
+			warnings = append(warnings, Warning{Pos: st.Pos, Code: WarnErrorOnly, State: st.Name,
+				Msg: fmt.Sprintf("state %q is only reachable through error transitions", st.Name)})
+		}
+
+		if len(st.Events) == 0 && !st.Final {
+			warnings = append(warnings, Warning{Pos: st.Pos, Code: WarnDeadEnd, State: st.Name,
+				Msg: fmt.Sprintf("state %q has no outgoing events", st.Name)})
+		}
+	}
+
+	return warnings
+}
+
+// reachableStates returns the states reachable from the initial state by
+// targetState transitions and, if errors is set, errorState transitions
+func reachableStates(s Schema, initial string, errors bool) map[string]bool {
+	events := make(map[string][]CustomEvent)
+	for _, st := range s.States {
+		events[st.Name] = append(events[st.Name], st.Events...)
+	}
+
+	reached := map[string]bool{initial: true}
+	pending := []string{initial}
+	for len(pending) > 0 {
+		cur := pending[0]
+		pending = pending[1:]
+
+		for _, e := range events[cur] {
+			next := []string{e.TargetState}
+			if errors && e.ErrorState != "" {
+				next = append(next, e.ErrorState)
+			}
+
+			for _, n := range next {
+				if !reached[n] {
+					reached[n] = true
+					pending = append(pending, n)
+				}
+			}
+		}
+	}
+
+	return reached
+}
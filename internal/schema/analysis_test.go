@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zain-bahsarat/fsml/internal/parser"
+)
+
+func TestAnalyze(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid" errorState="failed"/>
+			</Events>
+		</new>
+		<paid>
+			<Events>
+				<Ship targetState="shipped"/>
+			</Events>
+		</paid>
+		<failed>
+			<Events>
+				<Retry targetState="new"/>
+				<Refund targetState="refunded"/>
+			</Events>
+		</failed>
+		<refunded/>
+		<shipped/>
+		<archived>
+			<Events>
+				<Restore targetState="new"/>
+			</Events>
+		</archived>
+	</States>
+</Schema>`
+
+	s, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.Nil(t, err)
+
+	var got []string
+	for _, w := range Analyze(*s) {
+		got = append(got, w.Code+" "+w.String())
+	}
+
+	assert.Equal(t, []string{
+		`error-only orders.xml:13:3: state "failed" is only reachable through error transitions`,
+		`error-only orders.xml:19:3: state "refunded" is only reachable through error transitions`,
+		`dead-end orders.xml:19:3: state "refunded" has no outgoing events`,
+		`dead-end orders.xml:20:3: state "shipped" has no outgoing events`,
+		`unreachable orders.xml:21:3: state "archived" cannot be reached from the initial state "new"`,
+	}, got)
+}
+
+func TestAnalyze_InitialAndFinal(t *testing.T) {
+	input := `<Schema initial="draft">
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid"/>
+			</Events>
+		</new>
+		<draft>
+			<Events>
+				<Submit targetState="new"/>
+			</Events>
+		</draft>
+		<paid final="true"/>
+	</States>
+</Schema>`
+
+	s, err := New(parser.New(parser.NewLexer(input)))
+	assert.Nil(t, err)
+	assert.Empty(t, Analyze(*s))
+}
+
+func TestAnalyze_Empty(t *testing.T) {
+	assert.Nil(t, Analyze(Schema{}))
+}
@@ -0,0 +1,33 @@
+package schema
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zain-bahsarat/fsml/internal/parser"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add([]byte(`<Schema>
+	<OnBeforeEvent><Task>log</Task></OnBeforeEvent>
+	<States>
+		<new>
+			<OnStateSet><Task>notify</Task></OnStateSet>
+			<Events>
+				<Pay targetState="paid" errorState="error"><Task>charge</Task></Pay>
+			</Events>
+		</new>
+		<paid/>
+		<error/>
+	</States>
+</Schema>`))
+	f.Add([]byte(`<fsml:Schema xmlns:fsml="https://github.com/zain-bahsarat/fsml"><fsml:States><new/></fsml:States></fsml:Schema>`))
+	f.Add([]byte(`<Schema><Events/><OnStateSet/><States><new><Events><OnStateSet/></Events></new></States></Schema>`))
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		s, err := New(parser.NewWithLimits(parser.NewReaderLexer("", bytes.NewReader(src)), parser.Limits{MaxDepth: 64, MaxNodes: 10000}))
+		if (s == nil) == (err == nil) {
+			t.Fatalf("schema %v and error %v", s, err)
+		}
+	})
+}
@@ -3,12 +3,18 @@ package schema
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/zain-bahsarat/fsml/internal/parser"
 	"github.com/zain-bahsarat/fsml/internal/queue"
 )
 
+// Namespace identifies FSML elements embedded in other XML documents,
+// elements and attributes in no namespace are FSML as well
+const Namespace = "https://github.com/zain-bahsarat/fsml"
+
 const (
 	// Predefined Nodes
 	SchemaNodeName = "Schema"
@@ -24,6 +30,8 @@ const (
 	// Attributes
 	TargetState = "targetState"
 	ErrorState  = "errorState"
+	Initial     = "initial"
+	Final       = "final"
 )
 
 var defaultEvents = map[string]string{
@@ -53,9 +61,23 @@ type Conditions struct {
 	ParentNodeType parser.NodeType
 	NodeName       string
 	NodeType       parser.NodeType
+	Attributes     []parser.Attribute
+	Children       []parser.Node
+	Pos            parser.Pos
 	CustomFn       ConditionFn
 }
 
+// Attr returns the attribute with the local name, or nil
+func (c *Conditions) Attr(name string) *parser.Attribute {
+	for i := range c.Attributes {
+		if c.Attributes[i].Name == name {
+			return &c.Attributes[i]
+		}
+	}
+
+	return nil
+}
+
 func (c *Conditions) suffice(c1 Conditions) bool {
 	if len(c.ParentNodeName) > 0 && c1.ParentNodeName != c.ParentNodeName {
 		return false
@@ -82,10 +104,28 @@ func (c *Conditions) suffice(c1 Conditions) bool {
 
 type Rule struct {
 	Msg        string
+	MsgFn      func(c Conditions) string     // optional, builds the message from the node
+	PosFn      func(c Conditions) parser.Pos // optional, locates the error, the node by default
 	Criteria   Conditions
 	Validation Conditions
 }
 
+func (r *Rule) Message(c Conditions) string {
+	if r.MsgFn != nil {
+		return r.MsgFn(c)
+	}
+
+	return r.Msg
+}
+
+func (r *Rule) Position(c Conditions) parser.Pos {
+	if r.PosFn != nil {
+		return r.PosFn(c)
+	}
+
+	return c.Pos
+}
+
 func (r *Rule) Applicable(c Conditions) bool {
 	return r.Criteria.suffice(c)
 }
@@ -98,13 +138,47 @@ type SchemaChecker struct {
 	root         parser.Node
 	states       map[string]bool
 	visitedNodes map[string]int
+	strict       bool
+	maxErrors    int
+
+	// stateList caches the sorted names of sc.states for suggestions
+	stateList []string
+}
+
+// Option configures how New validates a definition
+type Option func(*SchemaChecker)
+
+// WithMaxErrors sets the number of validation errors after which the
+// validation stops, zero means no limit
+func WithMaxErrors(n int) Option {
+	return func(sc *SchemaChecker) {
+		sc.maxErrors = n
+	}
+}
+
+// WithStrict sets whether elements and attributes missing from the schema
+// vocabulary are rejected, which is the default. Otherwise they are ignored.
+func WithStrict(strict bool) Option {
+	return func(sc *SchemaChecker) {
+		sc.strict = strict
+	}
 }
 
 func (sc *SchemaChecker) Validate() error {
 	var errorList []string
 	q := queue.New()
 
+	// the root refers to states before the traversal reaches them
+	if states := filterChildByName(&sc.root, StatesNodeName); states != nil {
+		for _, child := range states.Children {
+			if child.Type == parser.ElementNode {
+				sc.addState(child.Name)
+			}
+		}
+	}
+
 	q.Enqueue(SchemaNode{N: sc.root})
+traversal:
 	for len(q.Items()) > 0 {
 
 		qlen := len(q.Items())
@@ -114,15 +188,22 @@ func (sc *SchemaChecker) Validate() error {
 				return errors.New("type consversion error.")
 			}
 
-			sc.visitedNodes[cur.N.Name] += 1
-			if err := sc.applyRules(cur); err != nil {
-				errorList = append(errorList, err.Error())
+			if cur.N.Type != parser.TextNode {
+				sc.visitedNodes[cur.N.Name] += 1
+			}
+			errorList = append(errorList, sc.applyRules(cur)...)
+			errorList = append(errorList, duplicates(cur)...)
+			errorList = append(errorList, repeatedContainers(cur)...)
+			errorList = append(errorList, finalWithEvents(cur)...)
+			if sc.tooMany(errorList) {
+				errorList = append(errorList[:sc.maxErrors], fmt.Sprintf("%s: too many errors", cur.N.Pos))
+				break traversal
 			}
 
 			for _, child := range cur.N.Children {
 				// check if custom event
 				if child.Type == parser.ElementNode && cur.N.Name == StatesNodeName {
-					sc.states[child.Name] = true
+					sc.addState(child.Name)
 				}
 
 				q.Enqueue(SchemaNode{N: child, ParentNodeName: cur.N.Name, ParentNodeType: cur.N.Type})
@@ -130,10 +211,17 @@ func (sc *SchemaChecker) Validate() error {
 		}
 	}
 
+	if sc.strict && sc.root.Name == SchemaNodeName && !sc.tooMany(errorList) {
+		errorList = append(errorList, checkVocabulary(sc.root, schemaKind)...)
+		if sc.tooMany(errorList) {
+			errorList = append(errorList[:sc.maxErrors], fmt.Sprintf("%s: too many errors", sc.root.Pos))
+		}
+	}
+
 	// check required nodes
 	for _, required := range sc.requiredNodes() {
 		if _, ok := sc.visitedNodes[required]; !ok {
-			errorList = append(errorList, fmt.Sprintf("Missing %s node", required))
+			errorList = append(errorList, fmt.Sprintf("%s: Missing %s node", sc.root.Pos, required))
 		}
 	}
 
@@ -151,26 +239,30 @@ func (sc *SchemaChecker) requiredNodes() []string {
 	}
 }
 
-func (sc *SchemaChecker) applyRules(node SchemaNode) error {
-
+// applyRules returns the messages of all the rules the node violates
+func (sc *SchemaChecker) applyRules(node SchemaNode) []string {
+	var errs []string
 	for _, rule := range sc.validationRules() {
 		c := Conditions{
 			ParentNodeName: node.ParentNodeName,
 			ParentNodeType: node.ParentNodeType,
 			NodeName:       node.N.Name,
 			NodeType:       node.N.Type,
+			Attributes:     node.N.Attributes,
+			Children:       node.N.Children,
+			Pos:            node.N.Pos,
 		}
 
 		if rule.Applicable(c) && !rule.Validate(c) {
-			return errors.New(rule.Msg)
+			errs = append(errs, fmt.Sprintf("%s: %s", rule.Position(c), rule.Message(c)))
 		}
 	}
 
-	return nil
+	return errs
 }
 
 func (sc *SchemaChecker) validationRules() []Rule {
-	return []Rule{
+	rules := []Rule{
 		{
 			Msg:        "Root Node is not Schema",
 			Criteria:   Conditions{NodeType: parser.RootNode},
@@ -195,8 +287,185 @@ func (sc *SchemaChecker) validationRules() []Rule {
 				return ok
 			}},
 		},
+		{
+			MsgFn: func(c Conditions) string {
+				return fmt.Sprintf("Task name %q should not contain whitespace", c.NodeName)
+			},
+			Criteria: Conditions{NodeType: parser.TextNode, ParentNodeName: TaskNodeName},
+			Validation: Conditions{CustomFn: func(c Conditions) bool {
+				return strings.IndexFunc(c.NodeName, unicode.IsSpace) < 0
+			}},
+		},
+		{
+			Msg:      "Task name should not be empty",
+			Criteria: Conditions{NodeType: parser.ElementNode, NodeName: TaskNodeName},
+			Validation: Conditions{CustomFn: func(c Conditions) bool {
+				for _, child := range c.Children {
+					if child.Type == parser.TextNode && strings.TrimSpace(child.Name) != "" {
+						return true
+					}
+				}
+				return false
+			}},
+		},
+		sc.stateReferenceRule(TargetState, eventKind),
+		sc.stateReferenceRule(ErrorState, eventKind),
+		sc.stateReferenceRule(Initial, schemaKind),
+		{
+			MsgFn: func(c Conditions) string {
+				return fmt.Sprintf("%s must be \"true\" or \"false\", not %q", Final, c.Attr(Final).Value)
+			},
+			PosFn: func(c Conditions) parser.Pos {
+				return c.Attr(Final).Pos
+			},
+			Criteria: Conditions{CustomFn: func(c Conditions) bool {
+				return kindOf(c) == stateKind && c.Attr(Final) != nil
+			}},
+			Validation: Conditions{CustomFn: func(c Conditions) bool {
+				return c.Attr(Final).Value == "true" || c.Attr(Final).Value == "false"
+			}},
+		},
 		// Extend the validation rules
 	}
+
+	return append(rules, requiredAttributeRules()...)
+}
+
+// duplicates reports states declared more than once and events declared
+// more than once in a state, with the locations of both declarations
+func duplicates(node SchemaNode) []string {
+	var kind, scope string
+	switch {
+	case node.N.Type != parser.ElementNode:
+		return nil
+	case node.N.Name == StatesNodeName:
+		kind = "state"
+	case node.N.Name == EventsNodeName:
+		kind, scope = "event", fmt.Sprintf(" in state %q", node.ParentNodeName)
+	default:
+		return nil
+	}
+
+	var errs []string
+	seen := make(map[string]parser.Pos)
+	for _, child := range node.N.Children {
+		if child.Type != parser.ElementNode || isDefaultEventNode(child.Name) {
+			continue
+		}
+
+		if first, ok := seen[child.Name]; ok {
+			errs = append(errs, fmt.Sprintf("%s: %s %q is already declared%s at %s", child.Pos, kind, child.Name, scope, first))
+			continue
+		}
+		seen[child.Name] = child.Pos
+	}
+
+	return errs
+}
+
+// repeatedContainers reports a second <States> in the schema and a second
+// <Events> in a state, only the first of them would be built
+func repeatedContainers(node SchemaNode) []string {
+	var name, scope string
+	switch {
+	case node.N.Type == parser.RootNode:
+		name = StatesNodeName
+	case node.N.Type == parser.ElementNode && node.ParentNodeName == StatesNodeName:
+		name, scope = EventsNodeName, fmt.Sprintf(" in state %q", node.N.Name)
+	default:
+		return nil
+	}
+
+	var errs []string
+	var first *parser.Pos
+	for _, child := range node.N.Children {
+		if child.Type != parser.ElementNode || child.Name != name {
+			continue
+		}
+
+		if first != nil {
+			errs = append(errs, fmt.Sprintf("%s: <%s> is already declared%s at %s", child.Pos, name, scope, *first))
+			continue
+		}
+		pos := child.Pos
+		first = &pos
+	}
+
+	return errs
+}
+
+// finalWithEvents reports final states which have outgoing events, at the
+// first of the events
+func finalWithEvents(node SchemaNode) []string {
+	if node.N.Type != parser.ElementNode || node.N.Name != StatesNodeName {
+		return nil
+	}
+
+	var errs []string
+	for _, st := range node.N.Children {
+		if st.Type != parser.ElementNode || !isFinal(&st) {
+			continue
+		}
+
+		events := filterChildByName(&st, EventsNodeName)
+		if events == nil {
+			continue
+		}
+
+		for _, evt := range events.Children {
+			if evt.Type == parser.ElementNode && !isDefaultEventNode(evt.Name) {
+				errs = append(errs, fmt.Sprintf("%s: final state %q must not have outgoing events", evt.Pos, st.Name))
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+// stateReferenceRule checks that the attribute of elements of kind k
+// names a declared state
+func (sc *SchemaChecker) stateReferenceRule(attribute string, k kind) Rule {
+	return Rule{
+		MsgFn: func(c Conditions) string {
+			value := c.Attr(attribute).Value
+			return fmt.Sprintf("%s %q is not a declared state%s", attribute, value, didYouMean(value, sc.stateNames()))
+		},
+		PosFn: func(c Conditions) parser.Pos {
+			return c.Attr(attribute).Pos
+		},
+		Criteria: Conditions{CustomFn: func(c Conditions) bool {
+			return kindOf(c) == k && c.Attr(attribute) != nil
+		}},
+		Validation: Conditions{CustomFn: func(c Conditions) bool {
+			return sc.states[c.Attr(attribute).Value]
+		}},
+	}
+}
+
+func (sc *SchemaChecker) addState(name string) {
+	if !sc.states[name] {
+		sc.states[name] = true
+		sc.stateList = nil
+	}
+}
+
+// stateNames returns the declared states in alphabetical order
+func (sc *SchemaChecker) stateNames() []string {
+	if sc.stateList == nil {
+		sc.stateList = make([]string, 0, len(sc.states))
+		for name := range sc.states {
+			sc.stateList = append(sc.stateList, name)
+		}
+		sort.Strings(sc.stateList)
+	}
+
+	return sc.stateList
+}
+
+// tooMany reports whether errs exceeds the error limit
+func (sc *SchemaChecker) tooMany(errs []string) bool {
+	return sc.maxErrors > 0 && len(errs) > sc.maxErrors
 }
 
 // ===============================================
@@ -208,43 +477,69 @@ type DefaultEvents struct {
 }
 
 type Event struct {
-	Tasks []string
+	Tasks []TaskRef
 }
 
 func (e *Event) Copy() Event {
-	tasks := make([]string, len(e.Tasks))
+	tasks := make([]TaskRef, len(e.Tasks))
 	copy(tasks, e.Tasks)
 	return Event{Tasks: tasks}
 }
 
+// TaskRef is a Task element, Pos is its location in the definition
+type TaskRef struct {
+	Name string
+	Pos  parser.Pos
+}
+
 type CustomEvent struct {
 	Name        string
-	Tasks       []string
+	Pos         parser.Pos
+	Tasks       []TaskRef
 	TargetState string
 	ErrorState  string
 }
 
 type Schema struct {
 	DefaultEvents
-	States []State
+	Initial string // declared initial state, see InitialState
+	States  []State
+}
+
+// InitialState returns the state new entities start in, the declared one
+// or else the first state
+func (s *Schema) InitialState() string {
+	if s.Initial != "" {
+		return s.Initial
+	} else if len(s.States) > 0 {
+		return s.States[0].Name
+	}
+
+	return ""
 }
 
 type State struct {
 	DefaultEvents
 	Name   string
+	Pos    parser.Pos
+	Final  bool // a terminal state, it has no events
 	Events []CustomEvent
 }
 
-func New(p *parser.Parser) (*Schema, error) {
+func New(p *parser.Parser, opts ...Option) (*Schema, error) {
 
 	ast := p.Parse()
 	if len(p.Errors()) > 0 {
-		return nil, fmt.Errorf("Parsing errors: \n%s ", strings.Join(p.Errors(), "\n"))
+		return nil, fmt.Errorf("Parsing errors: \n%w", p.Errors())
 	} else if ast == nil {
 		return nil, errors.New("No nodes found")
 	}
 
-	checker := SchemaChecker{root: *ast, states: make(map[string]bool), visitedNodes: make(map[string]int)}
+	ast = fsmlTree(ast)
+	checker := SchemaChecker{root: *ast, states: make(map[string]bool), visitedNodes: make(map[string]int), strict: true}
+	for _, opt := range opts {
+		opt(&checker)
+	}
 	if err := checker.Validate(); err != nil {
 		return nil, fmt.Errorf("Schema validation - %s", err.Error())
 	}
@@ -252,16 +547,82 @@ func New(p *parser.Parser) (*Schema, error) {
 	return buildFromAST(ast)
 }
 
+// fsmlTree returns the FSML part of the document. When the root element is
+// not FSML, the first Schema element in the FSML namespace is used. Elements
+// and attributes of other namespaces are left out.
+func fsmlTree(ast *parser.Node) *parser.Node {
+	root := ast
+	if !isFSML(ast.Namespace) {
+		if n := findSchema(ast); n != nil {
+			root = n
+		}
+	}
+
+	tree := withoutForeign(*root)
+	tree.Type = parser.RootNode
+	return &tree
+}
+
+func isFSML(namespace string) bool {
+	return namespace == "" || namespace == Namespace
+}
+
+func findSchema(ast *parser.Node) *parser.Node {
+	for i := range ast.Children {
+		child := &ast.Children[i]
+		if child.Type != parser.ElementNode {
+			continue
+		}
+
+		if child.Namespace == Namespace && child.Name == SchemaNodeName {
+			return child
+		}
+
+		if n := findSchema(child); n != nil {
+			return n
+		}
+	}
+
+	return nil
+}
+
+func withoutForeign(n parser.Node) parser.Node {
+	if len(n.Attributes) > 0 {
+		attributes := make([]parser.Attribute, 0, len(n.Attributes))
+		for _, attr := range n.Attributes {
+			if isFSML(attr.Namespace) {
+				attributes = append(attributes, attr)
+			}
+		}
+		n.Attributes = attributes
+	}
+
+	if len(n.Children) > 0 {
+		children := make([]parser.Node, 0, len(n.Children))
+		for _, child := range n.Children {
+			if child.Type == parser.ElementNode && !isFSML(child.Namespace) {
+				continue
+			}
+			children = append(children, withoutForeign(child))
+		}
+		n.Children = children
+	}
+
+	return n
+}
+
 func buildFromAST(ast *parser.Node) (*Schema, error) {
 	schema := Schema{}
 	schema.DefaultEvents = buildDefaultEvents(ast)
+	schema.Initial, _ = ast.Attr(Initial)
 	schema.States = buildStates(ast)
 	return &schema, nil
 }
 
+// filterChildByName returns the first child element with the name, or nil
 func filterChildByName(ast *parser.Node, name string) *parser.Node {
 	for _, child := range ast.Children {
-		if child.Name == name {
+		if child.Type == parser.ElementNode && child.Name == name {
 			return &child
 		}
 	}
@@ -284,7 +645,9 @@ func buildStates(ast *parser.Node) []State {
 
 	if sts := filterChildByName(ast, "States"); sts != nil {
 		for _, st := range sts.Children {
-			states = append(states, buildState(&st))
+			if st.Type == parser.ElementNode {
+				states = append(states, buildState(&st))
+			}
 		}
 	}
 
@@ -299,10 +662,17 @@ func buildState(ast *parser.Node) State {
 
 	state.DefaultEvents = buildDefaultEvents(ast)
 	state.Name = ast.Name
+	state.Pos = ast.Pos
+	state.Final = isFinal(ast)
 
 	return state
 }
 
+func isFinal(ast *parser.Node) bool {
+	value, _ := ast.Attr(Final)
+	return value == "true"
+}
+
 func buildDefaultEvents(ast *parser.Node) DefaultEvents {
 	events := DefaultEvents{}
 	for _, child := range ast.Children {
@@ -323,30 +693,28 @@ func buildCustomEvents(ast *parser.Node) []CustomEvent {
 	events := make([]CustomEvent, 0)
 	for _, child := range ast.Children {
 
-		if isDefaultEventNode(child.Name) {
+		if child.Type != parser.ElementNode || isDefaultEventNode(child.Name) {
 			continue
 		}
 
-		customEvt := CustomEvent{Name: child.Name, Tasks: buildTasks(&child)}
-		for _, attr := range child.Attributes {
-			switch attr.Name {
-			case TargetState:
-				customEvt.TargetState = attr.Value
-			case ErrorState:
-				customEvt.ErrorState = attr.Value
-			}
-		}
+		customEvt := CustomEvent{Name: child.Name, Pos: child.Pos, Tasks: buildTasks(&child)}
+		customEvt.TargetState, _ = child.Attr(TargetState)
+		customEvt.ErrorState, _ = child.Attr(ErrorState)
 
 		events = append(events, customEvt)
 	}
 	return events
 }
 
-func buildTasks(ast *parser.Node) []string {
-	tasks := make([]string, 0)
+func buildTasks(ast *parser.Node) []TaskRef {
+	tasks := make([]TaskRef, 0)
 	for _, child := range ast.Children {
+		if child.Name != TaskNodeName {
+			continue
+		}
+
 		if tn := filterChildByNodeType(&child, string(parser.TextNode)); tn != nil {
-			tasks = append(tasks, tn.Name)
+			tasks = append(tasks, TaskRef{Name: tn.Name, Pos: child.Pos})
 		}
 	}
 	return tasks
@@ -2,6 +2,7 @@ package schema
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/zain-bahsarat/fsml/internal/parser"
@@ -31,11 +32,17 @@ func TestNew(t *testing.T) {
 							</DummyEvent>
 						</Events>
 					</new>
+					<pending/>
+					<error/>
 				</States>
 			</Schema>`,
 			expected: &Schema{
-				DefaultEvents: DefaultEvents{OnBeforeEvent: Event{Tasks: []string{"task1"}}},
-				States:        []State{{Name: "new", DefaultEvents: DefaultEvents{OnBeforeEvent: Event{Tasks: []string{"task1"}}}, Events: []CustomEvent{{Name: "DummyEvent", TargetState: "pending", ErrorState: "error", Tasks: []string{"t1", "t2"}}}}},
+				DefaultEvents: DefaultEvents{OnBeforeEvent: Event{Tasks: tasks("task1")}},
+				States: []State{
+					{Name: "new", DefaultEvents: DefaultEvents{OnBeforeEvent: Event{Tasks: tasks("task1")}}, Events: []CustomEvent{{Name: "DummyEvent", TargetState: "pending", ErrorState: "error", Tasks: tasks("t1", "t2")}}},
+					{Name: "pending"},
+					{Name: "error"},
+				},
 			},
 		},
 	}
@@ -46,12 +53,419 @@ func TestNew(t *testing.T) {
 		s, err := New(p)
 
 		assert.Nil(t, err)
-		assert.Equal(t, tt.expected, s, fmt.Sprintf("tests[%d] - schema error", i))
+		assert.Equal(t, tt.expected, withoutPos(s), fmt.Sprintf("tests[%d] - schema error", i))
 	}
 }
 
+func TestNew_Comments(t *testing.T) {
+	input := `<!-- orders -->
+<Schema>
+	<States>
+		<!-- entry state -->
+		<new>
+			<Events>
+				<!-- moves to paid -->
+				<Pay targetState="paid"><Task>charge</Task></Pay>
+			</Events>
+		</new>
+		<paid></paid>
+	</States>
+</Schema>`
+
+	s, err := New(parser.New(parser.NewLexer(input)))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new", "paid"}, []string{s.States[0].Name, s.States[1].Name})
+	assert.Equal(t, []CustomEvent{{Name: "Pay", TargetState: "paid", Tasks: tasks("charge")}}, withoutPos(s).States[0].Events)
+}
+
+func TestNew_ErrorPositions(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+		</new>
+	</States>
+	<Events></Events>
+</Schema>`
+
+	p := parser.New(parser.NewFileLexer("orders.xml", input))
+	_, err := New(p)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "orders.xml:6:2: Events node should be inside State node")
+}
+
+func TestNew_TaskNameWhitespace(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="new">
+					<Task>
+						charge
+					</Task>
+					<Task>charge card</Task>
+					<Task>  </Task>
+					<Task/>
+					<Task><!-- todo --></Task>
+				</Pay>
+			</Events>
+		</new>
+	</States>
+</Schema>`
+
+	_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:9:12: Task name "charge card" should not contain whitespace`)
+	assert.NotContains(t, err.Error(), `"charge"`)
+	assert.Contains(t, err.Error(), `orders.xml:10:6: Task name should not be empty`)
+	assert.Contains(t, err.Error(), `orders.xml:11:6: Task name should not be empty`)
+	assert.Contains(t, err.Error(), `orders.xml:12:6: Task name should not be empty`)
+
+	// empty tasks are rejected in the lenient mode as well
+	_, err = New(parser.New(parser.NewFileLexer("orders.xml", input)), WithStrict(false))
+	assert.Contains(t, err.Error(), `orders.xml:11:6: Task name should not be empty`)
+}
+
+func TestNew_StateReferences(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="payed" errorState="Failed"/>
+				<Cancel targetState="new" errorState="shipped"/>
+			</Events>
+		</new>
+		<paid/>
+		<failed/>
+	</States>
+</Schema>`
+
+	_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:5:10: targetState "payed" is not a declared state, did you mean "paid"?`)
+	assert.Contains(t, err.Error(), `orders.xml:5:30: errorState "Failed" is not a declared state, did you mean "failed"?`)
+	assert.Contains(t, err.Error(), `orders.xml:6:31: errorState "shipped" is not a declared state`)
+	assert.NotContains(t, err.Error(), `"shipped" is not a declared state,`)
+	assert.NotContains(t, err.Error(), `"new"`)
+}
+
+func TestNew_MaxErrors(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("<Schema><States>\n")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&sb, "<s%d><Events><Go targetState=\"missing\"/></Events></s%d>\n", i, i)
+	}
+	sb.WriteString("</States></Schema>")
+
+	_, err := New(parser.New(parser.NewLexer(sb.String())), WithMaxErrors(3))
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, strings.Count(err.Error(), "is not a declared state"))
+	assert.Contains(t, err.Error(), "too many errors")
+
+	_, err = New(parser.New(parser.NewLexer(sb.String())))
+	assert.Equal(t, 10, strings.Count(err.Error(), "is not a declared state"))
+}
+
+func TestNew_Duplicates(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid"/>
+				<Cancel targetState="new"/>
+				<Pay targetState="new"/>
+			</Events>
+		</new>
+		<paid>
+			<Events>
+				<Cancel targetState="new"/>
+			</Events>
+		</paid>
+		<new/>
+	</States>
+</Schema>`
+
+	_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:15:3: state "new" is already declared at orders.xml:3:3`)
+	assert.Contains(t, err.Error(), `orders.xml:7:5: event "Pay" is already declared in state "new" at orders.xml:5:5`)
+	assert.NotContains(t, err.Error(), `"Cancel"`)
+}
+
+func TestNew_RepeatedContainers(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events><Pay targetState="new"/></Events>
+			<Events><Pay targetState="new"/></Events>
+		</new>
+	</States>
+	<States>
+		<paid final="true"/>
+	</States>
+</Schema>`
+
+	_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:8:2: <States> is already declared at orders.xml:2:2`)
+	assert.Contains(t, err.Error(), `orders.xml:5:4: <Events> is already declared in state "new" at orders.xml:4:4`)
+}
+
+func TestNew_RequiredAttributes(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<OnBeforeEvent/>
+			<Events>
+				<Pay errorState="new"><Task>charge</Task></Pay>
+				<Cancel targetState="new"/>
+			</Events>
+		</new>
+	</States>
+</Schema>`
+
+	for _, strict := range []bool{true, false} {
+		_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)), WithStrict(strict))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `orders.xml:6:5: event "Pay" is missing the required attribute "targetState"`)
+		assert.NotContains(t, err.Error(), `"Cancel"`)
+		assert.NotContains(t, err.Error(), `OnBeforeEvent`)
+	}
+}
+
+func TestNew_InitialAndFinal(t *testing.T) {
+	input := `<Schema initial="paid">
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid"/>
+			</Events>
+		</new>
+		<paid final="true"/>
+		<cancelled final="false"/>
+	</States>
+</Schema>`
+
+	s, err := New(parser.New(parser.NewLexer(input)))
+	assert.Nil(t, err)
+	assert.Equal(t, "paid", s.Initial)
+	assert.Equal(t, "paid", s.InitialState())
+	assert.Equal(t, []bool{false, true, false}, []bool{s.States[0].Final, s.States[1].Final, s.States[2].Final})
+
+	// the first state unless declared
+	assert.Equal(t, "new", (&Schema{States: s.States}).InitialState())
+	assert.Equal(t, "", (&Schema{}).InitialState())
+
+	input = `<Schema initial="nw">
+	<States>
+		<new final="yes"/>
+		<paid final="true">
+			<OnStateSet><Task>notify</Task></OnStateSet>
+			<Events>
+				<Refund targetState="new"/>
+				<Cancel targetState="new"/>
+			</Events>
+		</paid>
+	</States>
+</Schema>`
+
+	_, err = New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:1:9: initial "nw" is not a declared state, did you mean "new"?`)
+	assert.Contains(t, err.Error(), `orders.xml:3:8: final must be "true" or "false", not "yes"`)
+	assert.Contains(t, err.Error(), `orders.xml:7:5: final state "paid" must not have outgoing events`)
+	assert.NotContains(t, err.Error(), `orders.xml:8:`)
+}
+
+func TestNew_Strict(t *testing.T) {
+	input := `<Schema>
+	<OnBeforeEvnt/>
+	<States>
+		<new>
+			<OnStateSt><Task>notify</Task></OnStateSt>
+			<Events>
+				<Pay targetstate="paid" targetState="paid" note="x"><Tsk>charge</Tsk></Pay>
+			</Events>
+		</new>
+		<paid kind="final"/>
+	</States>
+</Schema>`
+
+	_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:2:2: unknown element <OnBeforeEvnt> in <Schema>, did you mean "OnBeforeEvent"?`)
+	assert.Contains(t, err.Error(), `orders.xml:5:4: unknown element <OnStateSt> in state "new", did you mean "OnStateSet"?`)
+	assert.Contains(t, err.Error(), `orders.xml:7:10: unknown attribute "targetstate" on event "Pay", did you mean "targetState"?`)
+	assert.Contains(t, err.Error(), `orders.xml:7:48: unknown attribute "note" on event "Pay"`+"\n")
+	assert.Contains(t, err.Error(), `orders.xml:7:57: unknown element <Tsk> in event "Pay", did you mean "Task"?`)
+	assert.Contains(t, err.Error(), `orders.xml:10:9: unknown attribute "kind" on state "paid"`)
+
+	s, err := New(parser.New(parser.NewFileLexer("orders.xml", input)), WithStrict(false))
+	assert.Nil(t, err)
+	assert.Equal(t, []CustomEvent{{Name: "Pay", TargetState: "paid", Tasks: tasks()}}, withoutPos(s).States[0].Events)
+}
+
+func TestNew_StrayText(t *testing.T) {
+	input := `<Schema>States
+	<States>draft<new final="true"/>
+		<paid>later<Events>later<Pay targetState="new">now<Task> charge </Task></Pay></Events></paid>
+	</States>
+</Schema>`
+
+	_, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:1:9: unexpected text in <Schema>`)
+	assert.Contains(t, err.Error(), `orders.xml:2:10: unexpected text in <States>`)
+	assert.Contains(t, err.Error(), `orders.xml:3:9: unexpected text in state "paid"`)
+	assert.Contains(t, err.Error(), `orders.xml:3:22: unexpected text in <Events>`)
+	assert.Contains(t, err.Error(), `orders.xml:3:50: unexpected text in event "Pay"`)
+	assert.NotContains(t, err.Error(), "<Task>")
+
+	// the text is no state or event of its own and does not hide elements of the same name
+	s, err := New(parser.New(parser.NewFileLexer("orders.xml", input)), WithStrict(false))
+	assert.Nil(t, err)
+	assert.Len(t, s.States, 2)
+	assert.Equal(t, "new", s.States[0].Name)
+	assert.Equal(t, []CustomEvent{{Name: "Pay", TargetState: "new", Tasks: tasks("charge")}}, withoutPos(s).States[1].Events)
+
+	// text does not count as the States element
+	_, err = New(parser.New(parser.NewLexer(`<Schema><OnBeforeEvent><Task>States</Task></OnBeforeEvent></Schema>`)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Missing States node")
+}
+
+func TestNew_Namespaces(t *testing.T) {
+	testcases := []string{
+		// embedded in a foreign document
+		`<config xmlns="urn:config" xmlns:fsml="https://github.com/zain-bahsarat/fsml">
+			<database url="postgres://"/>
+			<fsml:Schema>
+				<fsml:States>
+					<fsml:new cfg:owner="billing" xmlns:cfg="urn:config">
+						<fsml:Events>
+							<fsml:Pay targetState="paid" cfg:audit="true"><fsml:Task>charge</fsml:Task></fsml:Pay>
+						</fsml:Events>
+						<cfg:metadata><cfg:label>New</cfg:label></cfg:metadata>
+					</fsml:new>
+					<fsml:paid/>
+				</fsml:States>
+			</fsml:Schema>
+		</config>`,
+		// default namespace with foreign elements
+		`<Schema xmlns="https://github.com/zain-bahsarat/fsml" xmlns:doc="urn:doc">
+			<doc:title>Orders</doc:title>
+			<States>
+				<new>
+					<Events>
+						<Pay targetState="paid" doc:note="x"><Task>charge</Task></Pay>
+					</Events>
+				</new>
+				<paid/>
+			</States>
+		</Schema>`,
+	}
+
+	expected := &Schema{
+		States: []State{
+			{Name: "new", Events: []CustomEvent{{Name: "Pay", TargetState: "paid", Tasks: tasks("charge")}}},
+			{Name: "paid"},
+		},
+	}
+
+	for i, input := range testcases {
+		s, err := New(parser.New(parser.NewLexer(input)))
+
+		assert.Nil(t, err, "tests[%d]", i)
+		assert.Equal(t, expected, withoutPos(s), "tests[%d]", i)
+	}
+}
+
+func TestNew_ForeignRoot(t *testing.T) {
+	input := `<config xmlns:fsml="urn:other"><fsml:Schema><fsml:States/></fsml:Schema></config>`
+
+	_, err := New(parser.New(parser.NewFileLexer("service.xml", input)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "service.xml:1:1: Root Node is not Schema")
+}
+
+func TestNew_Positions(t *testing.T) {
+	input := `<Schema>
+	<OnBeforeEvent><Task>log</Task></OnBeforeEvent>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid">
+					<Task>charge</Task>
+				</Pay>
+			</Events>
+		</new>
+		<paid/>
+	</States>
+</Schema>`
+
+	s, err := New(parser.New(parser.NewFileLexer("orders.xml", input)))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "orders.xml:2:17", s.OnBeforeEvent.Tasks[0].Pos.String())
+	assert.Equal(t, "orders.xml:4:3", s.States[0].Pos.String())
+	assert.Equal(t, "orders.xml:6:5", s.States[0].Events[0].Pos.String())
+	assert.Equal(t, "orders.xml:7:6", s.States[0].Events[0].Tasks[0].Pos.String())
+	assert.Equal(t, "orders.xml:11:3", s.States[1].Pos.String())
+}
+
 func TestEvent(t *testing.T) {
-	e := Event{Tasks: []string{"A", "B"}}
+	e := Event{Tasks: tasks("A", "B")}
 
 	assert.Equal(t, e, e.Copy())
 }
+
+func tasks(names ...string) []TaskRef {
+	refs := make([]TaskRef, len(names))
+	for i, name := range names {
+		refs[i] = TaskRef{Name: name}
+	}
+
+	return refs
+}
+
+// withoutPos returns a copy of s without source positions
+func withoutPos(s *Schema) *Schema {
+	clearEvents := func(de DefaultEvents) DefaultEvents {
+		for _, e := range []*Event{&de.OnBeforeEvent, &de.OnAfterEvent, &de.OnStateSet} {
+			*e = Event{Tasks: clearTasks(e.Tasks)}
+		}
+		return de
+	}
+
+	c := &Schema{DefaultEvents: clearEvents(s.DefaultEvents)}
+	for _, st := range s.States {
+		st.Pos = parser.Pos{}
+		st.DefaultEvents = clearEvents(st.DefaultEvents)
+
+		if st.Events != nil {
+			events := make([]CustomEvent, len(st.Events))
+			for i, e := range st.Events {
+				e.Pos = parser.Pos{}
+				e.Tasks = clearTasks(e.Tasks)
+				events[i] = e
+			}
+			st.Events = events
+		}
+		c.States = append(c.States, st)
+	}
+
+	return c
+}
+
+func clearTasks(tasks []TaskRef) []TaskRef {
+	if tasks == nil {
+		return nil
+	}
+
+	refs := make([]TaskRef, len(tasks))
+	for i, task := range tasks {
+		refs[i] = TaskRef{Name: task.Name}
+	}
+
+	return refs
+}
@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// didYouMean returns a hint naming the candidate closest to name,
+// or an empty string when none of them is close enough
+func didYouMean(name string, candidates []string) string {
+	if s := suggest(name, candidates); s != "" {
+		return fmt.Sprintf(", did you mean %q?", s)
+	}
+
+	return ""
+}
+
+const (
+	// maxSuggestLen is the length of the longest name for which a
+	// suggestion is looked for
+	maxSuggestLen = 64
+	// maxCandidates bounds the edit distances computed for one name
+	maxCandidates = 200
+)
+
+// suggest returns the candidate with the smallest edit distance to name.
+// Names differing only in case always match, otherwise at most a third of
+// the characters, rounded up, may differ. Ties are broken alphabetically.
+// Long names get no suggestion and only the first maxCandidates candidates
+// of similar length are compared, so the time spent is bounded.
+func suggest(name string, candidates []string) string {
+	n := utf8.RuneCountInString(name)
+	if n > maxSuggestLen {
+		return ""
+	}
+
+	sorted := candidates
+	if !sort.StringsAreSorted(sorted) {
+		sorted = append([]string(nil), candidates...)
+		sort.Strings(sorted)
+	}
+
+	best, bestDist, compared := "", -1, 0
+	for _, c := range sorted {
+		if strings.EqualFold(c, name) {
+			return c
+		}
+
+		if diff := utf8.RuneCountInString(c) - n; diff > maxDistance(name) || -diff > maxDistance(name) {
+			continue
+		}
+		if compared++; compared > maxCandidates {
+			break
+		}
+
+		d := levenshtein(strings.ToLower(name), strings.ToLower(c))
+		if d <= maxDistance(name) && (bestDist < 0 || d < bestDist) {
+			best, bestDist = c, d
+		}
+	}
+
+	return best
+}
+
+func maxDistance(name string) int {
+	return (utf8.RuneCountInString(name) + 2) / 3
+}
+
+// levenshtein returns the number of single character insertions,
+// deletions and substitutions which turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
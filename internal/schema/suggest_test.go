@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggest(t *testing.T) {
+	states := []string{"new", "paid", "shipped", "in-review"}
+
+	testcases := []struct {
+		name     string
+		expected string
+	}{
+		{"payed", "paid"},
+		{"Paid", "paid"},
+		{"shiped", "shipped"},
+		{"in_review", "in-review"},
+		{"neww", "new"},
+		{"cancelled", ""},
+		{"x", ""},
+	}
+
+	for _, tt := range testcases {
+		assert.Equal(t, tt.expected, suggest(tt.name, states), tt.name)
+	}
+}
+
+func TestSuggest_Bounded(t *testing.T) {
+	long := strings.Repeat("a", maxSuggestLen)
+	assert.Equal(t, "", suggest(long+"b", []string{long + "c"}))
+
+	// candidates of a different length are not compared, the others are
+	// compared up to maxCandidates
+	var other, similar []string
+	for i := 0; i < maxCandidates; i++ {
+		other = append(other, fmt.Sprintf("a%08d", i))
+		similar = append(similar, fmt.Sprintf("a%04d", i))
+	}
+	assert.Equal(t, "zpaid", suggest("zpayd", append(other, "zpaid")))
+	assert.Equal(t, "", suggest("zpayd", append(similar, "zpaid")))
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("", ""))
+	assert.Equal(t, 3, levenshtein("", "new"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+	assert.Equal(t, 2, levenshtein("geprüft", "geprueft"))
+}
@@ -0,0 +1,193 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zain-bahsarat/fsml/internal/parser"
+)
+
+// kind is the role of an element in a definition
+type kind string
+
+const (
+	schemaKind       kind = "Schema"
+	statesKind       kind = "States"
+	stateKind        kind = "state"
+	eventsKind       kind = "Events"
+	eventKind        kind = "event"
+	defaultEventKind kind = "default event"
+	taskKind         kind = "Task"
+)
+
+// element describes the children and attributes an element accepts
+type element struct {
+	children   map[string]kind // child elements by name
+	named      kind            // kind of the children named by the user, e.g. states
+	attributes []string
+	required   []string // attributes which must be given
+}
+
+// vocabulary lists every element of a definition, what it may contain and
+// which attributes it requires. Anything else is unknown to the strict mode.
+var vocabulary = map[kind]element{
+	schemaKind: {
+		children: map[string]kind{
+			StatesNodeName: statesKind,
+			OnBeforeEvent:  defaultEventKind,
+			OnAfterEvent:   defaultEventKind,
+			OnStateSet:     defaultEventKind,
+		},
+		attributes: []string{Initial},
+	},
+	statesKind: {named: stateKind},
+	stateKind: {
+		children: map[string]kind{
+			EventsNodeName: eventsKind,
+			OnBeforeEvent:  defaultEventKind,
+			OnAfterEvent:   defaultEventKind,
+			OnStateSet:     defaultEventKind,
+		},
+		attributes: []string{Final},
+	},
+	eventsKind: {named: eventKind},
+	eventKind: {
+		children:   map[string]kind{TaskNodeName: taskKind},
+		attributes: []string{TargetState, ErrorState},
+		required:   []string{TargetState},
+	},
+	defaultEventKind: {children: map[string]kind{TaskNodeName: taskKind}},
+	taskKind:         {},
+}
+
+// checkVocabulary reports the elements and attributes below n which the
+// vocabulary does not know, n is an element of kind k
+func checkVocabulary(n parser.Node, k kind) []string {
+	var errs []string
+	el := vocabulary[k]
+
+	for _, attr := range n.Attributes {
+		if !contains(el.attributes, attr.Name) {
+			errs = append(errs, fmt.Sprintf("%s: unknown attribute %q on %s%s",
+				attr.Pos, attr.QualifiedName(), describe(n, k), didYouMean(attr.Name, el.attributes)))
+		}
+	}
+
+	for _, child := range n.Children {
+		// only tasks have text, whitespace between elements is ignored
+		if child.Type == parser.TextNode && k != taskKind && strings.TrimSpace(child.Name) != "" {
+			errs = append(errs, fmt.Sprintf("%s: unexpected text in %s", child.Pos, describe(n, k)))
+			continue
+		}
+
+		if child.Type != parser.ElementNode {
+			continue
+		}
+
+		if el.named != "" {
+			errs = append(errs, checkVocabulary(child, el.named)...)
+			continue
+		}
+
+		if ck, ok := el.children[child.Name]; ok {
+			errs = append(errs, checkVocabulary(child, ck)...)
+			continue
+		}
+
+		// misplaced Events and default events are reported by the validation rules
+		if child.Name == EventsNodeName || isDefaultEventNode(child.Name) {
+			continue
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: unknown element <%s> in %s%s",
+			child.Pos, child.QualifiedName(), describe(n, k), didYouMean(child.Name, el.childNames())))
+	}
+
+	return errs
+}
+
+// kindOf returns the kind of the element described by c, or an empty
+// string for elements which are not part of the vocabulary
+func kindOf(c Conditions) kind {
+	switch {
+	case c.NodeType == parser.RootNode:
+		return schemaKind
+	case c.NodeType != parser.ElementNode:
+		return ""
+	case c.ParentNodeName == StatesNodeName:
+		return stateKind
+	case c.ParentNodeName == EventsNodeName && !isDefaultEventNode(c.NodeName):
+		return eventKind
+	case isDefaultEventNode(c.NodeName):
+		return defaultEventKind
+	}
+
+	switch c.NodeName {
+	case StatesNodeName, EventsNodeName, TaskNodeName:
+		return kind(c.NodeName)
+	}
+
+	return ""
+}
+
+// describe names the element n of kind k in messages
+func describe(n parser.Node, k kind) string {
+	if k == stateKind || k == eventKind {
+		return fmt.Sprintf("%s %q", k, n.Name)
+	}
+
+	return "<" + n.QualifiedName() + ">"
+}
+
+// requiredAttributeRules returns a rule for every attribute the vocabulary
+// requires
+func requiredAttributeRules() []Rule {
+	kinds := make([]string, 0, len(vocabulary))
+	for k := range vocabulary {
+		kinds = append(kinds, string(k))
+	}
+	sort.Strings(kinds)
+
+	var rules []Rule
+	for _, k := range kinds {
+		k := kind(k)
+		for _, attribute := range vocabulary[k].required {
+			attribute := attribute
+			rules = append(rules, Rule{
+				MsgFn: func(c Conditions) string {
+					n := parser.Node{Name: c.NodeName}
+					return fmt.Sprintf("%s is missing the required attribute %q", describe(n, k), attribute)
+				},
+				Criteria: Conditions{CustomFn: func(c Conditions) bool {
+					return kindOf(c) == k
+				}},
+				Validation: Conditions{CustomFn: func(c Conditions) bool {
+					return c.Attr(attribute) != nil
+				}},
+			})
+		}
+	}
+
+	return rules
+}
+
+func (el element) childNames() []string {
+	names := make([]string, 0, len(el.children))
+	for name := range el.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package fsml
+
+import (
+	"io"
+
+	"github.com/zain-bahsarat/fsml/ast"
+	"github.com/zain-bahsarat/fsml/internal/parser"
+)
+
+// ParseFile parses the definition read from src and returns its tree,
+// positions refer to filename. The definition is not validated against the
+// schema and no state machine is built. When the definition is malformed
+// the error is an ast.ErrorList and the tree holds what could be parsed,
+// it is nil when there is no root element. Comments after the root element
+// are added to its trailing comments, processing instructions and the
+// DOCTYPE are not part of the tree.
+func ParseFile(filename string, src io.Reader, opts ...Option) (*ast.Node, error) {
+	o := options{limits: DefaultLimits}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.filename = filename
+
+	p := o.newParser(src)
+	root := p.Parse()
+
+	var tree *ast.Node
+	if root != nil {
+		tree = toAST(root)
+		for _, n := range p.Epilog() {
+			if n.Type == parser.CommentNode {
+				tree.TrailingComments = append(tree.TrailingComments, toAST(&n))
+			}
+		}
+	}
+
+	if errs := p.Errors(); len(errs) > 0 {
+		return tree, toASTErrors(errs)
+	}
+
+	return tree, nil
+}
+
+func toASTErrors(errs parser.ErrorList) ast.ErrorList {
+	list := make(ast.ErrorList, len(errs))
+	for i, err := range errs {
+		list[i] = &ast.Error{Pos: ast.Pos(err.Pos), Code: string(err.Code), Msg: err.Msg, Actual: string(err.Actual.Type)}
+		for _, t := range err.Expected {
+			list[i].Expected = append(list[i].Expected, string(t))
+		}
+	}
+
+	return list
+}
+
+func toAST(n *parser.Node) *ast.Node {
+	node := &ast.Node{
+		Name:             n.Name,
+		Prefix:           n.Prefix,
+		Namespace:        n.Namespace,
+		Pos:              ast.Pos(n.Pos),
+		Comments:         toASTList(n.Comments),
+		TrailingComments: toASTList(n.TrailingComments),
+	}
+
+	switch n.Type {
+	case parser.TextNode:
+		node.Kind, node.Name, node.Text = ast.Text, "", n.Name
+	case parser.CommentNode:
+		node.Kind, node.Name, node.Text = ast.Comment, "", n.Name
+	default:
+		node.Kind = ast.Element
+	}
+
+	for _, attr := range n.Attributes {
+		node.Attributes = append(node.Attributes, ast.Attribute{
+			Name:      attr.Name,
+			Prefix:    attr.Prefix,
+			Namespace: attr.Namespace,
+			Value:     attr.Value,
+			Pos:       ast.Pos(attr.Pos),
+		})
+	}
+	node.Children = toASTList(n.Children)
+
+	return node
+}
+
+func toASTList(nodes []parser.Node) []*ast.Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	list := make([]*ast.Node, len(nodes))
+	for i := range nodes {
+		list[i] = toAST(&nodes[i])
+	}
+
+	return list
+}
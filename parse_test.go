@@ -0,0 +1,63 @@
+package fsml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zain-bahsarat/fsml/ast"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFile(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<!-- entry state -->
+		<new>
+			<Events>
+				<Pay targetState="paid"><Task>charge</Task></Pay>
+			</Events>
+		</new>
+		<paid/>
+	</States>
+</Schema>
+<!-- end -->`
+
+	tree, err := ParseFile("orders.xml", strings.NewReader(input))
+	assert.Nil(t, err)
+	assert.Equal(t, ast.Element, tree.Kind)
+	assert.Equal(t, "Schema", tree.Name)
+
+	var events []*ast.Node
+	ast.Inspect(tree, func(n *ast.Node) bool {
+		if n != nil && n.Kind == ast.Element && n.Name == "Pay" {
+			events = append(events, n)
+		}
+		return true
+	})
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, "orders.xml:6:5", events[0].Pos.String())
+	assert.Equal(t, []ast.Attribute{{Name: "targetState", Value: "paid", Pos: ast.Pos{Filename: "orders.xml", Line: 6, Column: 10}}}, events[0].Attributes)
+
+	task := events[0].Children[0]
+	assert.Equal(t, &ast.Node{Kind: ast.Text, Text: "charge", Pos: ast.Pos{Filename: "orders.xml", Line: 6, Column: 35}}, task.Children[0])
+
+	states := tree.Elements("States")[0]
+	assert.Equal(t, "entry state", states.Children[0].Comments[0].Text)
+	assert.Equal(t, ast.Comment, states.Children[0].Comments[0].Kind)
+	assert.Equal(t, "end", tree.TrailingComments[0].Text)
+}
+
+func TestParseFile_Errors(t *testing.T) {
+	// the tree is returned even though the definition is malformed, it is not validated
+	tree, err := ParseFile("orders.xml", strings.NewReader("<Machine>\n<States>\n</Machine>"))
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "Machine", tree.Name)
+	assert.Equal(t, ast.ErrorList{{Pos: ast.Pos{Filename: "orders.xml", Line: 2, Column: 1}, Code: "unclosed-tag", Msg: "element <States> is not closed"}}, err)
+
+	tree, err = ParseFile("empty.xml", strings.NewReader(""))
+	assert.Nil(t, tree)
+	assert.Equal(t, "empty.xml:1:1: document has no root element", err.Error())
+}
@@ -1,37 +1,199 @@
 package fsml
 
 import (
+	"fmt"
 	"io"
-	"io/ioutil"
+	"sort"
 
+	"github.com/pkg/errors"
+	"github.com/zain-bahsarat/fsml/ast"
 	"github.com/zain-bahsarat/fsml/internal/parser"
 	"github.com/zain-bahsarat/fsml/internal/schema"
 )
 
 // Statemachine ...
 type Statemachine struct {
-	fsmWrapper *fsmWrapper
+	fsmWrapper  *fsmWrapper
+	warnings    []Warning
+	strictTasks bool
+}
+
+// Codes of the warnings about the states of a definition
+const (
+	WarnUnreachable = schema.WarnUnreachable // no transition leads to the state
+	WarnErrorOnly   = schema.WarnErrorOnly   // only error transitions lead to the state
+	WarnDeadEnd     = schema.WarnDeadEnd     // the state is not final and has no outgoing events
+)
+
+// Warning points out a likely mistake in a definition which loads
+// nonetheless, e.g. a state no transition leads to
+type Warning struct {
+	Pos   ast.Pos
+	Code  string
+	State string
+	Msg   string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Pos, w.Msg)
+}
+
+// Option configures how a definition is loaded by New
+type Option func(*options)
+
+type options struct {
+	filename    string
+	limits      Limits
+	backend     Backend
+	lenient     bool // unknown elements and attributes are ignored
+	fallback    map[string]string
+	strictTasks bool
+}
+
+// Backend selects the implementation which reads definitions, both
+// give the same result for well-formed documents
+type Backend int
+
+const (
+	// NativeBackend is the hand-written lexer, it recovers from
+	// malformed input and reports every problem of a definition
+	NativeBackend Backend = iota
+	// EncodingXMLBackend reads definitions with encoding/xml,
+	// it stops at the first malformed construct
+	EncodingXMLBackend
+)
+
+// Limits bound the resources used for loading a definition, so a schema
+// from an untrusted source cannot exhaust memory. A zero field means no limit.
+type Limits struct {
+	MaxBytes      int64 // size of the input in bytes
+	MaxDepth      int   // nesting depth of elements
+	MaxAttributes int   // attributes per element
+	MaxNodes      int   // element, text and comment nodes in the document
+	MaxErrors     int   // errors reported before parsing or validation stops
+}
+
+// DefaultLimits are applied by New unless WithLimits is given
+var DefaultLimits = Limits(parser.DefaultLimits)
+
+// WithFilename sets the name used in positions of parse and validation errors
+func WithFilename(name string) Option {
+	return func(o *options) {
+		o.filename = name
+	}
+}
+
+// WithLimits replaces the default resource limits
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithBackend selects the implementation which reads the definition,
+// NativeBackend is used by default
+func WithBackend(b Backend) Option {
+	return func(o *options) {
+		o.backend = b
+	}
+}
+
+// WithStrictSchema sets whether elements and attributes unknown to the schema
+// are rejected, which is the default. Otherwise they are ignored.
+func WithStrictSchema(strict bool) Option {
+	return func(o *options) {
+		o.lenient = !strict
+	}
+}
+
+// WithStateFallback maps states which are no longer defined, e.g. those of
+// entities stored by an earlier version of the definition, to defined states.
+// Entities in such a state are treated as being in the mapped one. Declared
+// states cannot have a fallback.
+func WithStateFallback(fallback map[string]string) Option {
+	return func(o *options) {
+		o.fallback = make(map[string]string, len(fallback))
+		for legacy, state := range fallback {
+			o.fallback[legacy] = state
+		}
+	}
+}
+
+// WithStrictTasks sets whether Trigger refuses to run while a task the
+// definition refers to is not registered, instead of failing the event when
+// it gets to the task. The error is a *ValidationError.
+func WithStrictTasks(strict bool) Option {
+	return func(o *options) {
+		o.strictTasks = strict
+	}
+}
+
+func (o *options) newParser(input io.Reader) *parser.Parser {
+	if o.backend == EncodingXMLBackend {
+		return parser.NewWithLimits(parser.NewXMLTokenizer(o.filename, input), parser.Limits(o.limits))
+	}
+
+	return parser.NewWithLimits(parser.NewReaderLexer(o.filename, input), parser.Limits(o.limits))
 }
 
 // New ...
-func New(input io.Reader) (*Statemachine, error) {
+//
+// When the definition is malformed the error is an ast.ErrorList, like the
+// one returned by ParseFile.
+func New(input io.Reader, opts ...Option) (*Statemachine, error) {
+	o := options{limits: DefaultLimits}
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-	buf, err := ioutil.ReadAll(input)
+	schma, err := schema.New(o.newParser(input), schema.WithStrict(!o.lenient), schema.WithMaxErrors(o.limits.MaxErrors))
 	if err != nil {
+		var errs parser.ErrorList
+		if errors.As(err, &errs) {
+			return nil, toASTErrors(errs)
+		}
 		return nil, err
 	}
 
-	p := parser.New(parser.NewLexer(string(buf)))
-	schma, err := schema.New(p)
-	if err != nil {
-		return nil, err
+	var warnings []Warning
+	for _, w := range schema.Analyze(*schma) {
+		warnings = append(warnings, Warning{Pos: ast.Pos(w.Pos), Code: w.Code, State: w.State, Msg: w.Msg})
+	}
+
+	wrapper := newFSMWrapper(*schma)
+	legacy := make([]string, 0, len(o.fallback))
+	for name := range o.fallback {
+		legacy = append(legacy, name)
+	}
+	sort.Strings(legacy)
+
+	for _, name := range legacy {
+		if _, ok := wrapper.statePositions[name]; ok {
+			return nil, fmt.Errorf("fallback of state %q: it is a declared state", name)
+		}
+		if _, ok := wrapper.statePositions[o.fallback[name]]; !ok {
+			return nil, fmt.Errorf("fallback of state %q: %q is not a declared state", name, o.fallback[name])
+		}
 	}
+	wrapper.fallback = o.fallback
+
+	return &Statemachine{fsmWrapper: wrapper, warnings: warnings, strictTasks: o.strictTasks}, nil
+}
 
-	return &Statemachine{fsmWrapper: newFSMWrapper(*schma)}, nil
+// Warnings returns the findings of the analysis of the states, in the
+// order the states are defined
+func (s *Statemachine) Warnings() []Warning {
+	return s.warnings
 }
 
 // Trigger ...
 func (s *Statemachine) Trigger(eventName string, entity interface{}) error {
+	if s.strictTasks {
+		if missing := s.fsmWrapper.missingTasks(); len(missing) > 0 {
+			return &ValidationError{Missing: missing}
+		}
+	}
+
 	fsm, err := s.fsmWrapper.newFSM(entity)
 	if err != nil {
 		return err
@@ -42,10 +204,10 @@ func (s *Statemachine) Trigger(eventName string, entity interface{}) error {
 		errorEvent := createFailedStateEvent(eventName)
 		if fsm.Can(errorEvent) {
 			if err := fsm.Event(errorEvent); err != nil {
-				return err
+				return s.fsmWrapper.triggerError(err)
 			}
 		} else {
-			return err
+			return s.fsmWrapper.triggerError(err)
 		}
 
 	}
@@ -55,6 +217,40 @@ func (s *Statemachine) Trigger(eventName string, entity interface{}) error {
 	return stateful.SetState(fsm.Current())
 }
 
+// Init puts the entity into the initial state of the definition, which is
+// the one named by the initial attribute of Schema or else the first state.
+// It fails when the definition declares no states. No tasks are executed.
+func (s *Statemachine) Init(entity interface{}) error {
+	stateful, err := asStateful(entity)
+	if err != nil {
+		return err
+	}
+
+	initial := s.fsmWrapper.schema.InitialState()
+	if initial == "" {
+		return errNoInitialState
+	}
+
+	return stateful.SetState(initial)
+}
+
+// IsFinal reports whether the entity is in a state marked final, the
+// workflow of the entity is done then. Fallback states count as the state
+// they are mapped to.
+func (s *Statemachine) IsFinal(entity interface{}) bool {
+	stateful, err := asStateful(entity)
+	if err != nil {
+		return false
+	}
+
+	state, err := s.fsmWrapper.currentState(stateful)
+	if err != nil {
+		return false
+	}
+
+	return s.fsmWrapper.finalStates[state]
+}
+
 // Can ...
 func (s *Statemachine) Can(eventName string, entity interface{}) bool {
 	fsm, err := s.fsmWrapper.newFSM(entity)
@@ -65,12 +261,38 @@ func (s *Statemachine) Can(eventName string, entity interface{}) bool {
 	return fsm.Can(eventName)
 }
 
+// Validate checks that every task the definition refers to is registered,
+// the error is a *ValidationError which also lists the unused tasks
+func (s *Statemachine) Validate() error {
+	missing := s.fsmWrapper.missingTasks()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Missing: missing, Unused: s.fsmWrapper.unusedTasks()}
+}
+
+// UnusedTasks returns the registered tasks the definition does not refer
+// to, sorted by name. They are harmless, e.g. tasks shared by several
+// definitions.
+func (s *Statemachine) UnusedTasks() []string {
+	return s.fsmWrapper.unusedTasks()
+}
+
+// MustValidate is like Validate but panics on missing tasks, it is meant for
+// the startup of a service once all tasks are added
+func (s *Statemachine) MustValidate() {
+	if err := s.Validate(); err != nil {
+		panic("fsml: " + err.Error())
+	}
+}
+
 // AddTask ...
 func (s *Statemachine) AddTask(task Task) error {
-	return s.fsmWrapper.taskCollection.addTask(task)
+	return s.fsmWrapper.addTask(task)
 }
 
 // RemoveTask ...
 func (s *Statemachine) RemoveTask(task Task) error {
-	return s.fsmWrapper.taskCollection.removeTask(task)
+	return s.fsmWrapper.removeTask(task)
 }
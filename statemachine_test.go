@@ -3,9 +3,12 @@ package fsml
 import (
 	"errors"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/looplab/fsm"
 	"github.com/stretchr/testify/assert"
+	"github.com/zain-bahsarat/fsml/ast"
 )
 
 // Test item
@@ -44,6 +47,443 @@ func TestStatemachine_Simple(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestStatemachine_Filename(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new></new>
+		</States
+	</Schema>`
+
+	_, err := New(strings.NewReader(input), WithFilename("orders.xml"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "orders.xml:4:3: closing tag </States> is missing '>'")
+}
+
+func TestStatemachine_XMLProlog(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE Schema>
+<Schema>
+	<States>
+		<new>
+			<Events>
+				<DummyEvent targetState="pending"></DummyEvent>
+			</Events>
+		</new>
+		<pending></pending>
+	</States>
+</Schema>`
+
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "new"}
+	assert.Nil(t, sm.Trigger("DummyEvent", item))
+	assert.Equal(t, "pending", item.GetState())
+}
+
+func TestStatemachine_SelfClosingTags(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new>
+				<Events>
+					<DummyEvent targetState="pending" errorState="error"/>
+				</Events>
+			</new>
+			<pending/>
+			<error/>
+		</States>
+	</Schema>`
+
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "new"}
+	assert.True(t, sm.Can("DummyEvent", item))
+	assert.Nil(t, sm.Trigger("DummyEvent", item))
+	assert.Equal(t, "pending", item.GetState())
+}
+
+func TestStatemachine_StateNames(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<awaiting.payment>
+				<Events>
+					<Pay targetState='in-review' errorState="payment-failed"/>
+				</Events>
+			</awaiting.payment>
+			<in-review/>
+			<payment-failed/>
+		</States>
+	</Schema>`
+
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "awaiting.payment"}
+	assert.Nil(t, sm.Trigger("Pay", item))
+	assert.Equal(t, "in-review", item.GetState())
+}
+
+func TestStatemachine_UTF8(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<neu>
+				<Events>
+					<Prüfen targetState="geprüft"/>
+				</Events>
+			</neu>
+			<geprüft/>
+		</States>
+	</Schema>`
+
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "neu"}
+	assert.Nil(t, sm.Trigger("Prüfen", item))
+	assert.Equal(t, "geprüft", item.GetState())
+
+	_, err = New(strings.NewReader("<Schema>\xff</Schema>"), WithFilename("orders.xml"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "orders.xml:1:9: invalid UTF-8 encoding")
+}
+
+func TestStatemachine_Limits(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new>
+				<Events>
+					<DummyEvent targetState="pending"/>
+				</Events>
+			</new>
+			<pending/>
+		</States>
+	</Schema>`
+
+	_, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	limits := DefaultLimits
+	limits.MaxDepth = 3
+	_, err = New(strings.NewReader(input), WithLimits(limits))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "4:5: element nesting exceeds the limit of 3")
+
+	_, err = New(strings.NewReader(input), WithLimits(Limits{MaxBytes: 64}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "input exceeds the limit of 64 bytes")
+}
+
+func TestStatemachine_TrailingContent(t *testing.T) {
+	input := `<Schema>
+		<States><new/></States>
+	</Schema>
+	<Schema>
+		<States><other/></States>
+	</Schema>`
+
+	_, err := New(strings.NewReader(input), WithFilename("orders.xml"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "orders.xml:4:2: unexpected element <Schema> after the root element")
+}
+
+func TestStatemachine_Backends(t *testing.T) {
+	input := `<?xml version="1.0"?>
+	<Schema>
+		<States>
+			<new>
+				<Events>
+					<Pay targetState="paid"><Task>charge</Task></Pay>
+				</Events>
+			</new>
+			<paid/>
+		</States>
+	</Schema>`
+
+	for _, backend := range []Backend{NativeBackend, EncodingXMLBackend} {
+		sm, err := New(strings.NewReader(input), WithBackend(backend))
+		assert.Nil(t, err)
+		assert.Nil(t, sm.AddTask(&testTask{name: "charge", executeFn: func(interface{}) error { return nil }}))
+
+		item := &testItem{state: "new"}
+		assert.Nil(t, sm.Trigger("Pay", item))
+		assert.Equal(t, "paid", item.GetState())
+
+		_, err = New(strings.NewReader(`<Schema><States><new></States></Schema>`), WithBackend(backend), WithFilename("orders.xml"))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "orders.xml:1:17: element <new> is not closed")
+	}
+
+	// encoding/xml stops at the first malformed construct
+	_, err := New(strings.NewReader("<Schema>\n<Task>&bogus;</Task>\n</Schema>"), WithBackend(EncodingXMLBackend))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "2:14: invalid character entity &bogus;")
+}
+
+func TestStatemachine_StrictSchema(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new>
+				<OnStateSt><Task>notify</Task></OnStateSt>
+				<Events>
+					<Pay targetState="paid"/>
+				</Events>
+			</new>
+			<paid/>
+		</States>
+	</Schema>`
+
+	_, err := New(strings.NewReader(input), WithFilename("orders.xml"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `orders.xml:4:5: unknown element <OnStateSt> in state "new", did you mean "OnStateSet"?`)
+
+	sm, err := New(strings.NewReader(input), WithStrictSchema(false))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "new"}
+	assert.Nil(t, sm.Trigger("Pay", item))
+	assert.Equal(t, "paid", item.GetState())
+}
+
+func TestStatemachine_Warnings(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new>
+				<Events>
+					<Pay targetState="paid" errorState="failed"/>
+				</Events>
+			</new>
+			<paid/>
+			<failed/>
+			<archived>
+				<Events>
+					<Restore targetState="new"/>
+				</Events>
+			</archived>
+		</States>
+	</Schema>`
+
+	sm, err := New(strings.NewReader(input), WithFilename("orders.xml"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Warning{
+		{Pos: ast.Pos{Filename: "orders.xml", Line: 8, Column: 4}, Code: WarnDeadEnd, State: "paid", Msg: `state "paid" has no outgoing events`},
+		{Pos: ast.Pos{Filename: "orders.xml", Line: 9, Column: 4}, Code: WarnErrorOnly, State: "failed", Msg: `state "failed" is only reachable through error transitions`},
+		{Pos: ast.Pos{Filename: "orders.xml", Line: 9, Column: 4}, Code: WarnDeadEnd, State: "failed", Msg: `state "failed" has no outgoing events`},
+		{Pos: ast.Pos{Filename: "orders.xml", Line: 10, Column: 4}, Code: WarnUnreachable, State: "archived", Msg: `state "archived" cannot be reached from the initial state "new"`},
+	}, sm.Warnings())
+	assert.Equal(t, `orders.xml:8:4: state "paid" has no outgoing events`, sm.Warnings()[0].String())
+}
+
+func TestStatemachine_InitialAndFinal(t *testing.T) {
+	input := `<Schema initial="draft">
+		<States>
+			<new>
+				<Events>
+					<Pay targetState="paid"/>
+				</Events>
+			</new>
+			<draft>
+				<Events>
+					<Submit targetState="new"/>
+				</Events>
+			</draft>
+			<paid final="true"/>
+		</States>
+	</Schema>`
+
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{}
+	assert.Nil(t, sm.Init(item))
+	assert.Equal(t, "draft", item.GetState())
+	assert.False(t, sm.IsFinal(item))
+
+	assert.Nil(t, sm.Trigger("Submit", item))
+	assert.Nil(t, sm.Trigger("Pay", item))
+	assert.True(t, sm.IsFinal(item))
+
+	assert.NotNil(t, sm.Init(struct{}{}))
+	assert.False(t, sm.IsFinal(struct{}{}))
+
+	// legacy states are final when their fallback is
+	sm, err = New(strings.NewReader(input), WithStateFallback(map[string]string{"settled": "paid"}))
+	assert.Nil(t, err)
+	assert.True(t, sm.IsFinal(&testItem{state: "settled"}))
+	assert.False(t, sm.IsFinal(&testItem{state: "unknown"}))
+
+	// without initial attribute entities start in the first state
+	sm, err = New(strings.NewReader(strings.Replace(input, ` initial="draft"`, "", 1)))
+	assert.Nil(t, err)
+	assert.Nil(t, sm.Init(item))
+	assert.Equal(t, "new", item.GetState())
+
+	// there is no initial state without states
+	sm, err = New(strings.NewReader(`<Schema><States/></Schema>`))
+	assert.Nil(t, err)
+	item = &testItem{state: "new"}
+	assert.NotNil(t, sm.Init(item))
+	assert.Equal(t, "new", item.GetState())
+}
+
+func TestStatemachine_UnknownState(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new>
+				<Events>
+					<Pay targetState="paid"/>
+				</Events>
+			</new>
+			<paid/>
+		</States>
+	</Schema>`
+
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "nwe"}
+	err = sm.Trigger("Pay", item)
+
+	var unknown *ErrUnknownState
+	assert.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "nwe", unknown.State)
+	assert.Equal(t, item, unknown.Entity)
+	assert.Contains(t, err.Error(), `unknown state "nwe" of entity`)
+	assert.Equal(t, "nwe", item.GetState())
+	assert.False(t, sm.Can("Pay", item))
+
+	// legacy states are mapped to declared ones
+	sm, err = New(strings.NewReader(input), WithStateFallback(map[string]string{"created": "new", "settled": "paid"}))
+	assert.Nil(t, err)
+
+	item = &testItem{state: "created"}
+	assert.True(t, sm.Can("Pay", item))
+	assert.Nil(t, sm.Trigger("Pay", item))
+	assert.Equal(t, "paid", item.GetState())
+
+	_, err = New(strings.NewReader(input), WithStateFallback(map[string]string{"settled": "payed", "created": "nw"}))
+	assert.NotNil(t, err)
+	assert.Equal(t, `fallback of state "created": "nw" is not a declared state`, err.Error())
+
+	_, err = New(strings.NewReader(input), WithStateFallback(map[string]string{"new": "paid"}))
+	assert.NotNil(t, err)
+	assert.Equal(t, `fallback of state "new": it is a declared state`, err.Error())
+}
+
+func TestStatemachine_Validate(t *testing.T) {
+	input := `<Schema>
+		<OnStateSet><Task>notify</Task></OnStateSet>
+		<States>
+			<new>
+				<Events>
+					<Pay targetState="paid" errorState="failed">
+						<Task>charge</Task>
+						<Task>notify</Task>
+					</Pay>
+				</Events>
+			</new>
+			<paid/>
+			<failed/>
+		</States>
+	</Schema>`
+
+	noop := func(interface{}) error { return nil }
+	sm, err := New(strings.NewReader(input), WithFilename("orders.xml"))
+	assert.Nil(t, err)
+	assert.Nil(t, sm.AddTask(&testTask{name: "notify", executeFn: noop}))
+	assert.Nil(t, sm.AddTask(&testTask{name: "refund", executeFn: noop}))
+	assert.Nil(t, sm.AddTask(&testTask{name: "audit", executeFn: noop}))
+
+	err = sm.Validate()
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, []TaskReference{{Name: "charge", Pos: ast.Pos{Filename: "orders.xml", Line: 7, Column: 7}}}, verr.Missing)
+	assert.Equal(t, []string{"audit", "refund"}, verr.Unused)
+	assert.Equal(t, "missing tasks: charge (orders.xml:7:7); unused tasks: audit, refund", err.Error())
+	assert.PanicsWithValue(t, "fsml: "+err.Error(), sm.MustValidate)
+
+	// unused tasks are no error
+	assert.Nil(t, sm.AddTask(&testTask{name: "charge", executeFn: noop}))
+	assert.Nil(t, sm.Validate())
+	assert.NotPanics(t, sm.MustValidate)
+	assert.Equal(t, []string{"audit", "refund"}, sm.UnusedTasks())
+
+	assert.Nil(t, sm.RemoveTask(&testTask{name: "refund"}))
+	assert.Nil(t, sm.RemoveTask(&testTask{name: "audit"}))
+	assert.Empty(t, sm.UnusedTasks())
+}
+
+func TestStatemachine_StrictTasks(t *testing.T) {
+	input := `<Schema>
+		<States>
+			<new>
+				<Events>
+					<Pay targetState="paid" errorState="failed"><Task>charge</Task></Pay>
+				</Events>
+			</new>
+			<paid/>
+			<failed/>
+		</States>
+	</Schema>`
+
+	// a missing task moves the entity to the error state
+	sm, err := New(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	item := &testItem{state: "new"}
+	assert.Nil(t, sm.Trigger("Pay", item))
+	assert.Equal(t, "failed", item.GetState())
+
+	// unless the tasks are checked before the event
+	sm, err = New(strings.NewReader(input), WithStrictTasks(true))
+	assert.Nil(t, err)
+	assert.Nil(t, sm.AddTask(&testTask{name: "unused", executeFn: func(interface{}) error { return nil }}))
+
+	item = &testItem{state: "new"}
+	err = sm.Trigger("Pay", item)
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, "charge", verr.Missing[0].Name)
+	assert.Empty(t, verr.Unused)
+	assert.Equal(t, "new", item.GetState())
+
+	assert.Nil(t, sm.AddTask(&testTask{name: "charge", executeFn: func(interface{}) error { return nil }}))
+	assert.Nil(t, sm.Trigger("Pay", item))
+	assert.Equal(t, "paid", item.GetState())
+
+	// removing the task is noticed as well
+	assert.Nil(t, sm.RemoveTask(&testTask{name: "charge"}))
+	assert.True(t, errors.As(sm.Trigger("Pay", &testItem{state: "new"}), &verr))
+
+	// the check is safe for concurrent triggers
+	sm, err = New(strings.NewReader(input), WithStrictTasks(true))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NotNil(t, sm.Trigger("Pay", &testItem{state: "new"}))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStatemachine_ParseErrors(t *testing.T) {
+	_, err := New(strings.NewReader("<Schema>\n<States><new a></new></States>\n</Schema>"), WithFilename("orders.xml"))
+
+	var errs ast.ErrorList
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, &ast.Error{
+		Pos:      ast.Pos{Filename: "orders.xml", Line: 2, Column: 15},
+		Code:     "unexpected-token",
+		Msg:      "expected next token to be =, got EndTag instead",
+		Expected: []string{"="},
+		Actual:   "EndTag",
+	}, errs[0])
+}
+
 func TestStatemachine_Simple_Trigger(t *testing.T) {
 	input := `<Schema>
 	<OnBeforeEvent>
@@ -219,6 +659,62 @@ func TestStatemachine_Invalid_Event(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestStatemachine_SourceLocations(t *testing.T) {
+	input := `<Schema>
+	<States>
+		<new>
+			<Events>
+				<Pay targetState="paid">
+					<Task>validate</Task>
+					<Task>charge</Task>
+				</Pay>
+				<Cancel targetState="cancelled">
+					<Task>refund</Task>
+				</Cancel>
+			</Events>
+		</new>
+		<paid/>
+		<cancelled/>
+	</States>
+</Schema>`
+
+	sm, err := New(strings.NewReader(input), WithFilename("orders.xml"))
+	assert.Nil(t, err)
+
+	declined := errors.New("card declined")
+	assert.Nil(t, sm.AddTask(&testTask{name: "validate", executeFn: func(interface{}) error { return nil }}))
+	assert.Nil(t, sm.AddTask(&testTask{name: "charge", executeFn: func(interface{}) error { return declined }}))
+
+	item := &testItem{state: "new"}
+	err = sm.Trigger("Pay", item)
+	assert.Equal(t, "task charge (orders.xml:7:6) failed: card declined", err.Error())
+	assert.True(t, errors.Is(err, declined))
+	assert.Equal(t, "new", item.GetState())
+
+	var taskErr *TaskError
+	assert.True(t, errors.As(err, &taskErr))
+	assert.Equal(t, "charge", taskErr.Task)
+	assert.Equal(t, 7, taskErr.Pos.Line)
+
+	// the canceled transition is still reported
+	var canceled fsm.CanceledError
+	assert.True(t, errors.As(err, &canceled))
+	assert.Equal(t, taskErr, canceled.Err)
+
+	// a task which is not registered
+	err = sm.Trigger("Cancel", item)
+	assert.Equal(t, "task refund (orders.xml:10:6) failed: task not found", err.Error())
+
+	// an event which is not defined for the current state
+	item.state = "paid"
+	err = sm.Trigger("Pay", item)
+	assert.Equal(t, "event Pay inappropriate in current state paid (orders.xml:14:3)", err.Error())
+
+	var eventErr *EventError
+	assert.True(t, errors.As(err, &eventErr))
+	assert.Equal(t, EventError{Event: "Pay", State: "paid", Pos: eventErr.Pos, Err: eventErr.Err}, *eventErr)
+}
+
 func TestStatemachine_Invalid_Entity(t *testing.T) {
 	input := `<Schema>
 		<States>
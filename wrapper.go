@@ -2,9 +2,14 @@ package fsml
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/looplab/fsm"
 	"github.com/pkg/errors"
+	"github.com/zain-bahsarat/fsml/ast"
+	"github.com/zain-bahsarat/fsml/internal/parser"
 	S "github.com/zain-bahsarat/fsml/internal/schema"
 )
 
@@ -12,6 +17,7 @@ var (
 	errTaskNotFound             = errors.New("task not found")
 	errTaskAlreadyExists        = errors.New("task already exists")
 	errMissingStatefulInterface = errors.New("must implement stateful interface")
+	errNoInitialState           = errors.New("definition has no initial state")
 )
 
 // Stateful ...
@@ -26,13 +32,102 @@ type Task interface {
 	Execute(entity interface{}) error
 }
 
-func buildTasksLookup(schema S.Schema) map[string][]string {
+// TaskError is returned by Trigger when a task of the event fails or is
+// not registered, Pos is the location of the Task element
+type TaskError struct {
+	Task string
+	Pos  ast.Pos
+	Err  error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %s (%s) failed: %s", e.Task, e.Pos, e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// As lets errors.As find the fsm.CanceledError of the transition the task
+// canceled, which is what Trigger returned before tasks had locations
+func (e *TaskError) As(target interface{}) bool {
+	if canceled, ok := target.(*fsm.CanceledError); ok {
+		*canceled = fsm.CanceledError{Err: e}
+		return true
+	}
+
+	return false
+}
+
+// EventError is returned by Trigger when the event is not defined for the
+// current state of the entity, Pos is the location of the state definition
+type EventError struct {
+	Event string
+	State string
+	Pos   ast.Pos
+	Err   error
+}
+
+func (e *EventError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Err.Error()
+	}
 
-	lookupTable := make(map[string][]string)
+	return fmt.Sprintf("%s (%s)", e.Err, e.Pos)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnknownState is returned by Trigger when the current state of the
+// entity is not defined in the definition and has no fallback
+type ErrUnknownState struct {
+	State  string
+	Entity interface{}
+}
+
+func (e *ErrUnknownState) Error() string {
+	return fmt.Sprintf("unknown state %q of entity %+v", e.State, e.Entity)
+}
 
-	addToLookup := func(key string, data []string) {
+// TaskReference is a Task element of the definition
+type TaskReference struct {
+	Name string
+	Pos  ast.Pos
+}
+
+// ValidationError is returned by Validate when tasks the definition refers
+// to are not registered, the unused tasks are listed for information
+type ValidationError struct {
+	Missing []TaskReference // first reference to each task which is not registered
+	Unused  []string        // registered tasks the definition does not refer to
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		missing := make([]string, len(e.Missing))
+		for i, ref := range e.Missing {
+			missing[i] = fmt.Sprintf("%s (%s)", ref.Name, ref.Pos)
+		}
+		parts = append(parts, "missing tasks: "+strings.Join(missing, ", "))
+	}
+
+	if len(e.Unused) > 0 {
+		parts = append(parts, "unused tasks: "+strings.Join(e.Unused, ", "))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func buildTasksLookup(schema S.Schema) map[string][]S.TaskRef {
+
+	lookupTable := make(map[string][]S.TaskRef)
+
+	addToLookup := func(key string, data []S.TaskRef) {
 		if _, ok := lookupTable[key]; !ok {
-			lookupTable[key] = []string{}
+			lookupTable[key] = []S.TaskRef{}
 		}
 		lookupTable[key] = append(lookupTable[key], data...)
 	}
@@ -122,8 +217,11 @@ func buildFSMCallbacks(schema S.Schema, cb func(trigger string, event *fsm.Event
 
 		for _, e := range s.Events {
 			setDefaultEvents(e.Name, s.DefaultEvents)
-			callbacks["before_"+e.Name] = func(event *fsm.Event) {
-				cb("before_"+e.Name, event)
+
+			// the callback must not capture the loop variable
+			trigger := "before_" + e.Name
+			callbacks[trigger] = func(event *fsm.Event) {
+				cb(trigger, event)
 			}
 		}
 	}
@@ -131,6 +229,43 @@ func buildFSMCallbacks(schema S.Schema, cb func(trigger string, event *fsm.Event
 	return callbacks
 }
 
+// taskReferences returns the first reference to every task, in the order
+// of the definition
+func taskReferences(schema S.Schema) []S.TaskRef {
+	var refs []S.TaskRef
+	addEvents := func(events ...S.Event) {
+		for _, e := range events {
+			refs = append(refs, e.Tasks...)
+		}
+	}
+
+	addEvents(schema.OnBeforeEvent, schema.OnAfterEvent, schema.OnStateSet)
+	for _, s := range schema.States {
+		addEvents(s.OnBeforeEvent, s.OnAfterEvent, s.OnStateSet)
+		for _, e := range s.Events {
+			refs = append(refs, e.Tasks...)
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].Pos.Line != refs[j].Pos.Line {
+			return refs[i].Pos.Line < refs[j].Pos.Line
+		}
+		return refs[i].Pos.Column < refs[j].Pos.Column
+	})
+
+	seen := make(map[string]bool)
+	first := refs[:0]
+	for _, ref := range refs {
+		if !seen[ref.Name] {
+			seen[ref.Name] = true
+			first = append(first, ref)
+		}
+	}
+
+	return first
+}
+
 func createFailedStateEvent(eventName string) string {
 	return fmt.Sprintf("%s_failed", eventName)
 }
@@ -139,7 +274,16 @@ type fsmWrapper struct {
 	schema          S.Schema
 	events          []fsm.EventDesc
 	taskCollection  taskCollection
-	taskLookupTable map[string][]string
+	taskLookupTable map[string][]S.TaskRef
+	statePositions  map[string]parser.Pos
+	finalStates     map[string]bool
+	fallback        map[string]string // declared states of legacy ones
+	taskReferences  []S.TaskRef
+
+	// missing holds the first reference to each task which is not
+	// registered, it is updated when a task is added or removed
+	mu      sync.RWMutex
+	missing []TaskReference
 }
 
 func newFSMWrapper(schema S.Schema) *fsmWrapper {
@@ -147,44 +291,169 @@ func newFSMWrapper(schema S.Schema) *fsmWrapper {
 	events := buildFSMEvents(schema)
 	lookupTable := buildTasksLookup(schema)
 
-	return &fsmWrapper{
+	statePositions := make(map[string]parser.Pos)
+	finalStates := make(map[string]bool)
+	for _, s := range schema.States {
+		statePositions[s.Name] = s.Pos
+		finalStates[s.Name] = s.Final
+	}
+
+	wrapper := &fsmWrapper{
 		schema:          schema,
 		events:          events,
 		taskCollection:  tCollection,
 		taskLookupTable: lookupTable,
+		statePositions:  statePositions,
+		finalStates:     finalStates,
+		taskReferences:  taskReferences(schema),
 	}
+	wrapper.updateMissing()
+
+	return wrapper
 }
 
-func (wrapper *fsmWrapper) newFSM(entity interface{}) (*fsm.FSM, error) {
+// asStateful returns the entity as Stateful, or an error when it does not
+// implement the interface
+func asStateful(entity interface{}) (Stateful, error) {
 	stateful, ok := entity.(Stateful)
 	if !ok {
 		return nil, errors.Wrap(errMissingStatefulInterface, fmt.Sprintf("%+v: ", entity))
 	}
 
+	return stateful, nil
+}
+
+func (wrapper *fsmWrapper) newFSM(entity interface{}) (*fsm.FSM, error) {
+	stateful, err := asStateful(entity)
+	if err != nil {
+		return nil, err
+	}
+
 	callbacks := buildFSMCallbacks(wrapper.schema, func(trigger string, event *fsm.Event) {
-		if taskNames, ok := wrapper.taskLookupTable[trigger]; ok {
-			for _, taskName := range taskNames {
-				task, err := wrapper.taskCollection.get(taskName)
+		if tasks, ok := wrapper.taskLookupTable[trigger]; ok {
+			for _, ref := range tasks {
+				task, err := wrapper.taskCollection.get(ref.Name)
 				if err != nil {
-					event.Cancel(err)
+					event.Cancel(&TaskError{Task: ref.Name, Pos: ast.Pos(ref.Pos), Err: errTaskNotFound})
 					return
 				}
 
 				if err := task.Execute(entity); err != nil {
-					event.Cancel(err)
+					event.Cancel(&TaskError{Task: ref.Name, Pos: ast.Pos(ref.Pos), Err: err})
 					return
 				}
 			}
 		}
 	})
 
+	current, err := wrapper.currentState(stateful)
+	if err != nil {
+		return nil, err
+	}
+
 	return fsm.NewFSM(
-		stateful.GetState(),
+		current,
 		wrapper.events,
 		callbacks,
 	), nil
 }
 
+// missingTasks returns the first reference to each task the definition
+// refers to which is not registered
+func (wrapper *fsmWrapper) missingTasks() []TaskReference {
+	wrapper.mu.RLock()
+	defer wrapper.mu.RUnlock()
+
+	return append([]TaskReference(nil), wrapper.missing...)
+}
+
+// updateMissing recomputes the missing tasks, the caller holds wrapper.mu
+func (wrapper *fsmWrapper) updateMissing() {
+	wrapper.missing = nil
+	for _, ref := range wrapper.taskReferences {
+		if _, err := wrapper.taskCollection.get(ref.Name); err != nil {
+			wrapper.missing = append(wrapper.missing, TaskReference{Name: ref.Name, Pos: ast.Pos(ref.Pos)})
+		}
+	}
+}
+
+// unusedTasks returns the registered tasks the definition does not refer
+// to, sorted by name
+func (wrapper *fsmWrapper) unusedTasks() []string {
+	wrapper.mu.RLock()
+	defer wrapper.mu.RUnlock()
+
+	referenced := make(map[string]bool)
+	for _, ref := range wrapper.taskReferences {
+		referenced[ref.Name] = true
+	}
+
+	var unused []string
+	for name := range wrapper.taskCollection.tasks {
+		if !referenced[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+
+	return unused
+}
+
+func (wrapper *fsmWrapper) addTask(t Task) error {
+	wrapper.mu.Lock()
+	defer wrapper.mu.Unlock()
+
+	if err := wrapper.taskCollection.addTask(t); err != nil {
+		return err
+	}
+	wrapper.updateMissing()
+
+	return nil
+}
+
+func (wrapper *fsmWrapper) removeTask(t Task) error {
+	wrapper.mu.Lock()
+	defer wrapper.mu.Unlock()
+
+	if err := wrapper.taskCollection.removeTask(t); err != nil {
+		return err
+	}
+	wrapper.updateMissing()
+
+	return nil
+}
+
+// currentState returns the state of the entity, states which are not
+// defined are replaced by their fallback
+func (wrapper *fsmWrapper) currentState(stateful Stateful) (string, error) {
+	state := stateful.GetState()
+	if _, ok := wrapper.statePositions[state]; ok {
+		return state, nil
+	}
+
+	if fallback, ok := wrapper.fallback[state]; ok {
+		return fallback, nil
+	}
+
+	return "", &ErrUnknownState{State: state, Entity: stateful}
+}
+
+// triggerError adds the source locations to an error of the event
+func (wrapper *fsmWrapper) triggerError(err error) error {
+	var canceled fsm.CanceledError
+	var taskErr *TaskError
+	if errors.As(err, &canceled) && errors.As(canceled.Err, &taskErr) {
+		return taskErr
+	}
+
+	var invalid fsm.InvalidEventError
+	if errors.As(err, &invalid) {
+		return &EventError{Event: invalid.Event, State: invalid.State, Pos: ast.Pos(wrapper.statePositions[invalid.State]), Err: err}
+	}
+
+	return err
+}
+
 type taskCollection struct {
 	tasks map[string]Task
 }
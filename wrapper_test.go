@@ -99,6 +99,8 @@ func TestWrapper_errTaskNotFound(t *testing.T) {
 					</DummyEvent>
 				</Events>
 			</new>
+			<pending/>
+			<error/>
 		</States>
 	</Schema>`
 	p := parser.New(parser.NewLexer(input))
@@ -158,6 +160,8 @@ func TestWrapper_TaskAlreayExists(t *testing.T) {
 					</DummyEvent>
 				</Events>
 			</new>
+			<pending/>
+			<error/>
 		</States>
 	</Schema>`
 	p := parser.New(parser.NewLexer(input))
@@ -200,6 +204,8 @@ func TestWrapper_TaskLookupTable(t *testing.T) {
 					</DummyEvent>
 				</Events>
 			</new>
+			<pending/>
+			<error/>
 		</States>
 	</Schema>`
 	p := parser.New(parser.NewLexer(input))
@@ -217,7 +223,15 @@ func TestWrapper_TaskLookupTable(t *testing.T) {
 		"enter_state":       {"dummy1"},
 	}
 
-	assert.Equal(t, expected, wrapper.taskLookupTable, "Lookup table is not equal")
+	names := make(map[string][]string)
+	for trigger, tasks := range wrapper.taskLookupTable {
+		for _, task := range tasks {
+			names[trigger] = append(names[trigger], task.Name)
+		}
+	}
+
+	assert.Equal(t, expected, names, "Lookup table is not equal")
+	assert.Equal(t, parser.Pos{Line: 14, Column: 6}, wrapper.taskLookupTable["before_DummyEvent"][0].Pos)
 }
 
 func TestWrapper_StatefulInterface(t *testing.T) {